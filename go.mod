@@ -5,6 +5,7 @@ go 1.17
 require (
 	github.com/go-kit/kit v0.8.0
 	github.com/go-openapi/runtime v0.19.21
+	github.com/go-openapi/strfmt v0.19.5
 	github.com/gophercloud/gophercloud v0.0.0-20180928224355-bfc006765209
 	github.com/hashicorp/go-multierror v1.0.0
 	github.com/namsral/flag v1.7.4-pre
@@ -12,6 +13,7 @@ require (
 	github.com/prometheus/client_golang v1.0.0
 	github.com/prometheus/common v0.4.1
 	github.com/prometheus/prometheus v2.3.2+incompatible
+	github.com/vmware/govmomi v0.30.0
 	gopkg.in/yaml.v2 v2.3.0
 	k8s.io/apimachinery v0.0.0-20170321210947-75b8dd260ef0
 	k8s.io/client-go v3.0.0-beta.0+incompatible
@@ -22,6 +24,7 @@ require (
 	github.com/Azure/go-autorest v7.2.2+incompatible // indirect
 	github.com/PuerkitoBio/purell v1.1.1 // indirect
 	github.com/PuerkitoBio/urlesc v0.0.0-20170810143723-de5bf2ad4578 // indirect
+	github.com/armon/go-metrics v0.0.0-20190430140413-ec5e00d3c878 // indirect
 	github.com/armon/go-radix v1.0.0 // indirect
 	github.com/asaskevich/govalidator v0.0.0-20200428143746-21a406dcc535 // indirect
 	github.com/aws/aws-sdk-go v0.0.0-20161102215928-707203bc5511 // indirect
@@ -40,7 +43,6 @@ require (
 	github.com/go-openapi/jsonreference v0.19.3 // indirect
 	github.com/go-openapi/loads v0.19.5 // indirect
 	github.com/go-openapi/spec v0.19.8 // indirect
-	github.com/go-openapi/strfmt v0.19.5 // indirect
 	github.com/go-openapi/swag v0.19.9 // indirect
 	github.com/go-openapi/validate v0.19.10 // indirect
 	github.com/go-stack/stack v1.8.0 // indirect
@@ -48,9 +50,11 @@ require (
 	github.com/golang/glog v0.0.0-20141105023935-44145f04b68c // indirect
 	github.com/golang/protobuf v1.3.1 // indirect
 	github.com/google/gofuzz v0.0.0-20161122191042-44d81051d367 // indirect
+	github.com/google/uuid v1.3.0 // indirect
 	github.com/hashicorp/consul v0.0.0-20170112012924-23ce10f88913 // indirect
 	github.com/hashicorp/errwrap v1.0.0 // indirect
 	github.com/hashicorp/go-cleanhttp v0.5.0 // indirect
+	github.com/hashicorp/go-msgpack v0.5.5 // indirect
 	github.com/hashicorp/golang-lru v0.5.3 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
 	github.com/hashicorp/memberlist v0.1.4 // indirect
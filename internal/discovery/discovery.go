@@ -72,6 +72,8 @@ func (d discovery) getDiscoveries() []string {
 func (d discovery) Start(ctx context.Context, cfg config.Config, opts config.Options) {
 	adapterList := make([]adapter.Adapter, 0)
 	discoveryList := make([]Discovery, 0)
+	duplicates := NewDuplicateAddresses(d.log)
+	prometheus.MustRegister(duplicates)
 
 	for name, discovery := range cfg.Discoveries {
 		level.Info(log.With(d.log, "component", "discovery")).Log("info", fmt.Sprintf("=============> Loading discovery: %s", name))
@@ -83,7 +85,7 @@ func (d discovery) Start(ctx context.Context, cfg config.Config, opts config.Opt
 
 		updates := make(chan []*targetgroup.Group)
 		go disc.Run(ctx, updates)
-		go disc.GetAdapter().Run(ctx, updates)
+		go disc.GetAdapter().Run(ctx, duplicates.Watch(ctx, name, updates))
 
 		adapterList = append(adapterList, disc.GetAdapter())
 		discoveryList = append(discoveryList, disc)
@@ -91,7 +93,7 @@ func (d discovery) Start(ctx context.Context, cfg config.Config, opts config.Opt
 		prometheus.MustRegister(NewMetricsCollector(disc.GetAdapter(), disc, opts.Version))
 
 	}
-	go NewServer(adapterList, discoveryList, d.log).Start()
+	go NewServer(adapterList, discoveryList, duplicates, d.log).Start()
 }
 
 func UnmarshalHandler(discIn, discOut, values interface{}) error {
@@ -0,0 +1,175 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+type (
+	// DuplicateAddresses watches the targets of all discoveries for addresses emitted by several objects,
+	// which usually is an ipam mistake
+	DuplicateAddresses struct {
+		sync.Mutex
+		groups     map[string][]*targetgroup.Group
+		duplicates []DuplicateAddress
+		desc       *prometheus.Desc
+		logger     log.Logger
+	}
+
+	// DuplicateAddress is an address with the objects emitting it
+	DuplicateAddress struct {
+		Address string            `json:"address"`
+		Objects []DuplicateObject `json:"objects"`
+	}
+
+	// DuplicateObject is an object of a discovery, identified by its server_name label or else its group source
+	DuplicateObject struct {
+		Discovery string            `json:"discovery"`
+		Object    string            `json:"object"`
+		Labels    map[string]string `json:"labels"`
+	}
+)
+
+func NewDuplicateAddresses(l log.Logger) *DuplicateAddresses {
+	return &DuplicateAddresses{
+		groups:     make(map[string][]*targetgroup.Group),
+		duplicates: make([]DuplicateAddress, 0),
+		desc: prometheus.NewDesc(
+			"atlas_duplicate_address",
+			"Number of objects emitting the same target address",
+			[]string{"address", "discoveries"},
+			nil),
+		logger: l,
+	}
+}
+
+// Watch records every update of the discovery and passes it on to the returned channel
+func (d *DuplicateAddresses) Watch(ctx context.Context, discovery string, updates <-chan []*targetgroup.Group) <-chan []*targetgroup.Group {
+	out := make(chan []*targetgroup.Group)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case tgroups := <-updates:
+				d.update(discovery, tgroups)
+				select {
+				case out <- tgroups:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+func (d *DuplicateAddresses) update(discovery string, tgroups []*targetgroup.Group) {
+	d.Lock()
+	defer d.Unlock()
+	d.groups[discovery] = tgroups
+
+	objects := make(map[string]map[string]DuplicateObject)
+	for disc, groups := range d.groups {
+		for _, group := range groups {
+			for _, target := range group.Targets {
+				address := string(target[model.AddressLabel])
+				if address == "" {
+					continue
+				}
+				object := DuplicateObject{Discovery: disc, Object: string(group.Labels[model.LabelName("server_name")])}
+				if object.Object == "" {
+					object.Object = group.Source
+				}
+				if objects[address] == nil {
+					objects[address] = make(map[string]DuplicateObject)
+				}
+				// Several targets of the same object (e.g. interfaces or jobs) aren't duplicates
+				key := disc + "/" + object.Object
+				if _, ok := objects[address][key]; !ok {
+					object.Labels = make(map[string]string, len(group.Labels))
+					for name, value := range group.Labels {
+						object.Labels[string(name)] = string(value)
+					}
+					objects[address][key] = object
+				}
+			}
+		}
+	}
+
+	d.duplicates = make([]DuplicateAddress, 0)
+	for address, objs := range objects {
+		if len(objs) < 2 {
+			continue
+		}
+		dup := DuplicateAddress{Address: address}
+		for _, o := range objs {
+			dup.Objects = append(dup.Objects, o)
+		}
+		sort.Slice(dup.Objects, func(i, j int) bool {
+			if dup.Objects[i].Discovery != dup.Objects[j].Discovery {
+				return dup.Objects[i].Discovery < dup.Objects[j].Discovery
+			}
+			return dup.Objects[i].Object < dup.Objects[j].Object
+		})
+		d.duplicates = append(d.duplicates, dup)
+	}
+	sort.Slice(d.duplicates, func(i, j int) bool { return d.duplicates[i].Address < d.duplicates[j].Address })
+	if len(d.duplicates) > 0 {
+		level.Debug(log.With(d.logger, "component", "duplicates")).Log("debug", fmt.Sprintf("found %d duplicate addresses", len(d.duplicates)))
+	}
+}
+
+func (d *DuplicateAddresses) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.desc
+}
+
+func (d *DuplicateAddresses) Collect(ch chan<- prometheus.Metric) {
+	d.Lock()
+	defer d.Unlock()
+	for _, dup := range d.duplicates {
+		ch <- prometheus.MustNewConstMetric(
+			d.desc,
+			prometheus.GaugeValue,
+			float64(len(dup.Objects)),
+			dup.Address,
+			dup.discoveries(),
+		)
+	}
+}
+
+// ServeHTTP lists the duplicate addresses with the conflicting objects
+func (d *DuplicateAddresses) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.Lock()
+	data, err := json.Marshal(d.duplicates)
+	d.Unlock()
+	if err != nil {
+		level.Error(log.With(d.logger, "component", "duplicates")).Log("error", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// discoveries returns the names of the discoveries emitting the address, comma separated
+func (dup DuplicateAddress) discoveries() string {
+	labels := make(map[string]string, 1)
+	names := make([]string, 0, len(dup.Objects))
+	for _, o := range dup.Objects {
+		names = append(names, o.Discovery)
+	}
+	setJoinedLabel(labels, "discoveries", names)
+	return labels["discoveries"]
+}
@@ -2,6 +2,7 @@ package discovery
 
 import (
 	"context"
+	"net/http"
 	"sync"
 
 	"github.com/go-kit/kit/log"
@@ -21,6 +22,17 @@ type Discovery interface {
 	GetAdapter() adapter.Adapter
 }
 
+// WebhookHandler is implemented by discoveries that accept change notifications from their source.
+// It serves /webhook/<name> and the paths below it.
+type WebhookHandler interface {
+	HandleWebhook(w http.ResponseWriter, r *http.Request)
+}
+
+// DriftHandler is implemented by discoveries that compare their source with another inventory
+type DriftHandler interface {
+	HandleDrift(w http.ResponseWriter, r *http.Request)
+}
+
 type DiscoveryFactory func(config interface{}, ctx context.Context, opts config.Options, l log.Logger) (Discovery, error)
 
 type Status struct {
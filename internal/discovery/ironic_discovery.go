@@ -22,6 +22,7 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"sync"
 	"time"
 
 	netbox_dcim "github.com/netbox-community/go-netbox/netbox/client/dcim"
@@ -55,17 +56,22 @@ type (
 		outputFile       string
 		metricsLabel     string
 		mgmtInterfaceIPs *bool
+		drift            *driftReport
+		driftMu          sync.Mutex
 	}
 	ironicConfig struct {
-		NetboxHost       string          `yaml:"netbox_host"`
-		NetboxAPIToken   string          `yaml:"netbox_api_token"`
-		MgmtInterfaceIPs *bool           `yaml:"mgmt_interface_ips"`
-		RefreshInterval  int             `yaml:"refresh_interval"`
-		RateLimiter      time.Duration   `yaml:"rate_limit"`
-		TargetsFileName  string          `yaml:"targets_file_name"`
-		OpenstackAuth    auth.OSProvider `yaml:"os_auth"`
-		MetricsLabel     string          `yaml:"metrics_label"`
-		ConfigmapName    string          `yaml:"configmap_name"`
+		NetboxHost       string              `yaml:"netbox_host"`
+		NetboxAPIToken   string              `yaml:"netbox_api_token"`
+		NetboxClient     netbox.ClientConfig `yaml:"netbox_client"`
+		MgmtInterfaceIPs *bool               `yaml:"mgmt_interface_ips"`
+		CacheTTL         netbox.CacheTTL     `yaml:"cache_ttl"`
+		RefreshInterval  int                 `yaml:"refresh_interval"`
+		RateLimiter      time.Duration       `yaml:"rate_limit"`
+		TargetsFileName  string              `yaml:"targets_file_name"`
+		OpenstackAuth    auth.OSProvider     `yaml:"os_auth"`
+		MetricsLabel     string              `yaml:"metrics_label"`
+		ConfigmapName    string              `yaml:"configmap_name"`
+		Drift            driftConfig         `yaml:"drift"`
 	}
 )
 
@@ -93,10 +99,11 @@ func NewIronicDiscovery(disc interface{}, ctx context.Context, opts config.Optio
 		return d, err
 	}
 
-	nClient, err := netbox.New(cfg.NetboxHost, cfg.NetboxAPIToken)
+	nClient, err := netbox.NewWithConfig(cfg.NetboxHost, cfg.NetboxAPIToken, cfg.NetboxClient)
 	if err != nil {
 		return nil, err
 	}
+	nClient.SetCacheTTL(cfg.CacheTTL)
 
 	var w writer.Writer
 	if cfg.ConfigmapName != "" {
@@ -128,6 +135,11 @@ func (d *IronicDiscovery) Run(ctx context.Context, ch chan<- []*targetgroup.Grou
 			d.rateLimiter.Stop()
 		}
 	}()
+	// Requests still running when the discovery is stopped are aborted
+	d.netbox = d.netbox.WithContext(ctx)
+	if d.cfg.Drift.Enabled {
+		go d.runDrift(ctx)
+	}
 	for c := time.Tick(time.Duration(d.refreshInterval) * time.Second); ; {
 		if d.cfg.RateLimiter > 0 && d.rateLimiter == nil {
 			d.rateLimiter = time.NewTicker(d.cfg.RateLimiter * time.Millisecond)
@@ -0,0 +1,262 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	netbox_dcim "github.com/netbox-community/go-netbox/netbox/client/dcim"
+	"github.com/netbox-community/go-netbox/netbox/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/atlas/pkg/clients"
+	"github.com/sapcc/atlas/pkg/netbox"
+)
+
+const (
+	driftMissingInNetbox = "missing_in_netbox"
+	driftMissingInIronic = "missing_in_ironic"
+	driftSerial          = "serial"
+	driftManufacturer    = "manufacturer"
+	driftModel           = "model"
+	driftBMCAddress      = "bmc_address"
+)
+
+type (
+	// driftConfig enables the comparison of the ironic nodes with the netbox devices
+	driftConfig struct {
+		Enabled bool `yaml:"enabled"`
+		// Interval between two reports in seconds, defaults to 3600
+		Interval int `yaml:"interval"`
+		// DeviceRole of the netbox devices expected in ironic, defaults to server
+		DeviceRole string `yaml:"device_role"`
+	}
+
+	driftReport struct {
+		Time            time.Time       `json:"time"`
+		MissingInNetbox []driftNode     `json:"missing_in_netbox"`
+		MissingInIronic []driftDevice   `json:"missing_in_ironic"`
+		Mismatches      []driftMismatch `json:"mismatches"`
+	}
+
+	driftNode struct {
+		UUID   string `json:"uuid"`
+		Name   string `json:"name"`
+		Serial string `json:"serial"`
+	}
+
+	driftDevice struct {
+		ID     int64  `json:"id"`
+		Name   string `json:"name"`
+		Serial string `json:"serial"`
+	}
+
+	// driftMismatch is a field which differs between a node and the device with the same name or serial
+	driftMismatch struct {
+		Node   driftNode   `json:"node"`
+		Device driftDevice `json:"device"`
+		Field  string      `json:"field"`
+		Ironic string      `json:"ironic"`
+		Netbox string      `json:"netbox"`
+	}
+)
+
+var inventoryDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "atlas_inventory_drift",
+	Help: "Number of differences between the ironic nodes and the netbox devices by kind",
+}, []string{"discovery", "metrics_label", "kind"})
+
+func init() {
+	prometheus.MustRegister(inventoryDrift)
+}
+
+// runDrift reports the drift between ironic and netbox every interval, until ctx is closed
+func (d *IronicDiscovery) runDrift(ctx context.Context) {
+	interval := time.Duration(d.cfg.Drift.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	for c := time.Tick(interval); ; {
+		report, err := d.driftReport()
+		if err != nil {
+			level.Error(log.With(d.logger, "component", "IronicDiscovery")).Log("error", fmt.Errorf("Error creating drift report: %w", err))
+		} else {
+			d.driftMu.Lock()
+			d.drift = report
+			d.driftMu.Unlock()
+			report.setMetrics(d.GetName(), d.metricsLabel)
+			level.Debug(log.With(d.logger, "component", "IronicDiscovery")).Log("debug", fmt.Sprintf("drift report: %d nodes missing in netbox, %d devices missing in ironic, %d mismatches",
+				len(report.MissingInNetbox), len(report.MissingInIronic), len(report.Mismatches)))
+		}
+		select {
+		case <-c:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// driftReport matches the nodes and devices by name, or else by serial, and compares the matched pairs
+func (d *IronicDiscovery) driftReport() (*driftReport, error) {
+	nodes, err := d.ironicClient.GetNodes()
+	if err != nil {
+		return nil, fmt.Errorf("Error loading ironic nodes: %w", err)
+	}
+	role := d.cfg.Drift.DeviceRole
+	if role == "" {
+		role = "server"
+	}
+	devices, err := d.netbox.DevicesByParams(netbox_dcim.DcimDevicesListParams{Role: &role}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading netbox devices: %w", err)
+	}
+	ids := make([]int64, 0, len(devices))
+	byName := make(map[string]int, len(devices))
+	bySerial := make(map[string]int, len(devices))
+	for i, dv := range devices {
+		ids = append(ids, dv.ID)
+		if dv.Name != nil {
+			byName[strings.ToLower(*dv.Name)] = i
+		}
+		if dv.Serial != "" {
+			bySerial[strings.ToLower(dv.Serial)] = i
+		}
+	}
+	mgmtIPs, err := d.netbox.BulkManagementIPs(ids)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading netbox management ips: %w", err)
+	}
+
+	report := &driftReport{
+		Time:            time.Now(),
+		MissingInNetbox: make([]driftNode, 0),
+		MissingInIronic: make([]driftDevice, 0),
+		Mismatches:      make([]driftMismatch, 0),
+	}
+	matched := make(map[int]bool, len(devices))
+	for _, node := range nodes {
+		n := driftNode{UUID: node.ID, Name: node.Name, Serial: node.Properties.SerialNumber}
+		i, ok := byName[strings.ToLower(node.Name)]
+		if !ok && n.Serial != "" {
+			i, ok = bySerial[strings.ToLower(n.Serial)]
+		}
+		if !ok {
+			report.MissingInNetbox = append(report.MissingInNetbox, n)
+			continue
+		}
+		matched[i] = true
+		report.compare(node, n, devices[i], mgmtIPs[devices[i].ID])
+	}
+	for i, dv := range devices {
+		if !matched[i] {
+			report.MissingInIronic = append(report.MissingInIronic, newDriftDevice(dv))
+		}
+	}
+	return report, nil
+}
+
+// compare adds the mismatches of the node and the device. Fields missing in ironic aren't compared.
+// Manufacturers and models match if one contains the other, e.g. "Dell Inc." and "Dell".
+func (r *driftReport) compare(node clients.IronicNode, n driftNode, dv models.DeviceWithConfigContext, ips []netbox.DeviceIP) {
+	device := newDriftDevice(dv)
+	mismatch := func(field, ironic, nb string) {
+		r.Mismatches = append(r.Mismatches, driftMismatch{Node: n, Device: device, Field: field, Ironic: ironic, Netbox: nb})
+	}
+	if n.Serial != "" && !strings.EqualFold(n.Serial, dv.Serial) {
+		mismatch(driftSerial, n.Serial, dv.Serial)
+	}
+	var manufacturer, model string
+	if dv.DeviceType != nil {
+		if dv.DeviceType.Manufacturer != nil && dv.DeviceType.Manufacturer.Name != nil {
+			manufacturer = *dv.DeviceType.Manufacturer.Name
+		}
+		if dv.DeviceType.Model != nil {
+			model = *dv.DeviceType.Model
+		}
+	}
+	if m := node.Properties.Manufacturer; m != "" && !similar(m, manufacturer) {
+		mismatch(driftManufacturer, m, manufacturer)
+	}
+	if m := node.Properties.Model; m != "" && !similar(m, model) {
+		mismatch(driftModel, m, model)
+	}
+	if bmc := node.DriverInfo.IpmiAddress; bmc != "" {
+		// The ipmi address can be a host name as well
+		bmcIPs := []string{bmc}
+		if net.ParseIP(bmc) == nil {
+			if resolved, err := net.LookupHost(bmc); err == nil {
+				bmcIPs = resolved
+			}
+		}
+		addresses := make([]string, 0, len(ips))
+		found := false
+		for _, ip := range ips {
+			addresses = append(addresses, ip.Address)
+			for _, bmcIP := range bmcIPs {
+				if parsed := net.ParseIP(bmcIP); parsed != nil && parsed.String() == ip.Address {
+					found = true
+				}
+			}
+		}
+		if !found {
+			mismatch(driftBMCAddress, bmc, strings.Join(addresses, ","))
+		}
+	}
+}
+
+// setMetrics exports the counts of the report, labelled with the discovery and its metrics label,
+// as several ironic discoveries can report their drift
+func (r *driftReport) setMetrics(discovery, metricsLabel string) {
+	counts := map[string]int{
+		driftMissingInNetbox: len(r.MissingInNetbox),
+		driftMissingInIronic: len(r.MissingInIronic),
+		driftSerial:          0,
+		driftManufacturer:    0,
+		driftModel:           0,
+		driftBMCAddress:      0,
+	}
+	for _, m := range r.Mismatches {
+		counts[m.Field]++
+	}
+	for kind, count := range counts {
+		inventoryDrift.WithLabelValues(discovery, metricsLabel, kind).Set(float64(count))
+	}
+}
+
+// HandleDrift returns the last drift report as json
+func (d *IronicDiscovery) HandleDrift(w http.ResponseWriter, r *http.Request) {
+	d.driftMu.Lock()
+	report := d.drift
+	d.driftMu.Unlock()
+	if report == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		level.Error(log.With(d.logger, "component", "IronicDiscovery")).Log("error", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func newDriftDevice(dv models.DeviceWithConfigContext) driftDevice {
+	device := driftDevice{ID: dv.ID, Serial: dv.Serial}
+	if dv.Name != nil {
+		device.Name = *dv.Name
+	}
+	return device
+}
+
+func similar(a, b string) bool {
+	a, b = strings.ToLower(strings.TrimSpace(a)), strings.ToLower(strings.TrimSpace(b))
+	return b != "" && (strings.Contains(a, b) || strings.Contains(b, a))
+}
@@ -0,0 +1,56 @@
+package discovery
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/common/model"
+	"github.com/sapcc/atlas/pkg/clients"
+	"k8s.io/client-go/kubernetes"
+)
+
+// kubernetesCluster is a cluster of a kubernetes discovery. Without a kubeconfig the in-cluster config is used.
+type kubernetesCluster struct {
+	// Name is the cluster label of the targets, defaults to the context
+	Name       string `yaml:"name"`
+	Kubeconfig string `yaml:"kubeconfig"`
+	// Context of the kubeconfig, defaults to its current context
+	Context string `yaml:"context"`
+}
+
+// kubernetesClient is the client of a configured cluster
+type kubernetesClient struct {
+	name   string
+	client *kubernetes.Clientset
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// newKubernetesClients creates a client per cluster, or one for the cluster atlas runs in if none is configured
+func newKubernetesClients(clusters []kubernetesCluster) (kcs []kubernetesClient, err error) {
+	if len(clusters) == 0 {
+		clusters = []kubernetesCluster{{}}
+	}
+	names := make(map[string]bool, len(clusters))
+	for _, c := range clusters {
+		if c.Name == "" {
+			c.Name = c.Context
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate kubernetes cluster %s", c.Name)
+		}
+		names[c.Name] = true
+		client, err := clients.NewKubernetesClient(c.Kubeconfig, c.Context)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating kubernetes client for cluster %s: %w", c.Name, err)
+		}
+		kcs = append(kcs, kubernetesClient{name: c.Name, client: client})
+	}
+	return
+}
+
+// kubernetesLabelName turns a kubernetes label or annotation name into a prometheus label name with the prefix,
+// e.g. label_topology_kubernetes_io_zone
+func kubernetesLabelName(prefix, name string) model.LabelName {
+	return model.LabelName(prefix + invalidLabelChars.ReplaceAllString(name, "_"))
+}
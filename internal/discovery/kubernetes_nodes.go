@@ -0,0 +1,250 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/sapcc/atlas/pkg/adapter"
+	"github.com/sapcc/atlas/pkg/config"
+	"github.com/sapcc/atlas/pkg/errgroup"
+	"github.com/sapcc/atlas/pkg/writer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const kubernetesNodesDiscovery = "kubernetes_nodes"
+
+const (
+	nodeRoleLabelPrefix = "node-role.kubernetes.io/"
+	nodeRoleLabel       = "kubernetes.io/role"
+	zoneLabel           = "topology.kubernetes.io/zone"
+	zoneLabelBeta       = "failure-domain.beta.kubernetes.io/zone"
+)
+
+type (
+	KubernetesNodesDiscovery struct {
+		cfg             kubernetesNodesConfig
+		adapter         adapter.Adapter
+		clusters        []kubernetesClient
+		refreshInterval int
+		logger          log.Logger
+		status          *Status
+		outputFile      string
+		metricsLabel    string
+	}
+
+	kubernetesNodesConfig struct {
+		Clusters []kubernetesCluster `yaml:"clusters"`
+		// LabelSelector selects the nodes, e.g. "kubernetes.cloud.sap/role=worker"
+		LabelSelector string `yaml:"label_selector"`
+		// Port is added to the node addresses if set
+		Port            int               `yaml:"port"`
+		RefreshInterval int               `yaml:"refresh_interval"`
+		TargetsFileName string            `yaml:"targets_file_name"`
+		MetricsLabel    string            `yaml:"metrics_label"`
+		CustomLabels    map[string]string `yaml:"custom_labels"`
+		ConfigmapName   string            `yaml:"configmap_name"`
+	}
+)
+
+func init() {
+	Register(kubernetesNodesDiscovery, NewKubernetesNodesDiscovery)
+}
+
+// NewKubernetesNodesDiscovery creates a new Kubernetes Nodes Discovery
+func NewKubernetesNodesDiscovery(disc interface{}, ctx context.Context, opts config.Options, l log.Logger) (d Discovery, err error) {
+	var cfg kubernetesNodesConfig
+	if err := UnmarshalHandler(disc, &cfg, nil); err != nil {
+		return d, err
+	}
+	clusters, err := newKubernetesClients(cfg.Clusters)
+	if err != nil {
+		level.Error(log.With(l, "component", "KubernetesNodesDiscovery")).Log("err", err)
+		return d, err
+	}
+
+	var w writer.Writer
+	if cfg.ConfigmapName != "" {
+		w, err = writer.NewConfigMap(cfg.ConfigmapName, opts.NameSpace, l)
+	} else {
+		w, err = writer.NewFile(cfg.TargetsFileName, l)
+	}
+
+	a := adapter.NewPrometheus(ctx, cfg.TargetsFileName, w, l)
+
+	return &KubernetesNodesDiscovery{
+		cfg:             cfg,
+		adapter:         a,
+		clusters:        clusters,
+		refreshInterval: cfg.RefreshInterval,
+		logger:          l,
+		status:          &Status{Up: false, Targets: make(map[string]int)},
+		outputFile:      cfg.TargetsFileName,
+		metricsLabel:    cfg.MetricsLabel,
+	}, nil
+}
+
+func (d *KubernetesNodesDiscovery) Run(ctx context.Context, ch chan<- []*targetgroup.Group) {
+	for c := time.Tick(time.Duration(d.refreshInterval) * time.Second); ; {
+		tgs, err := d.loadNodes()
+		d.status.Lock()
+		d.status.Up = err == nil
+		d.status.Unlock()
+		if err == nil {
+			level.Debug(log.With(d.logger, "component", "KubernetesNodesDiscovery")).Log("debug", "Done Loading Nodes")
+			ch <- tgs
+		} else {
+			level.Error(log.With(d.logger, "component", "KubernetesNodesDiscovery")).Log("error", err)
+		}
+		// Wait for ticker or exit when ctx is closed.
+		select {
+		case <-c:
+			continue
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *KubernetesNodesDiscovery) GetAdapter() adapter.Adapter {
+	return d.adapter
+}
+
+func (d *KubernetesNodesDiscovery) Up() bool {
+	return d.status.Up
+}
+
+func (d *KubernetesNodesDiscovery) Targets() map[string]int {
+	d.status.Targets = make(map[string]int)
+	setMetricsLabelAndValue(d.status.Targets, d.metricsLabel, d.adapter.GetNumberOfTargetsFor(d.metricsLabel))
+	return d.status.Targets
+}
+
+func (d *KubernetesNodesDiscovery) Lock() {
+	d.status.Lock()
+}
+
+func (d *KubernetesNodesDiscovery) Unlock() {
+	d.status.Unlock()
+}
+
+func (d *KubernetesNodesDiscovery) GetOutputFile() string {
+	return d.outputFile
+}
+
+func (d *KubernetesNodesDiscovery) GetName() string {
+	return kubernetesNodesDiscovery
+}
+
+// loadNodes lists the nodes of all clusters. A failing cluster fails the whole run, so that its targets aren't dropped.
+func (d *KubernetesNodesDiscovery) loadNodes() (tgroups []*targetgroup.Group, err error) {
+	var (
+		eg errgroup.Group
+		mu sync.Mutex
+	)
+	for _, kc := range d.clusters {
+		func(kc kubernetesClient) {
+			eg.Go(func() error {
+				nodes, err := kc.client.CoreV1().Nodes().List(metav1.ListOptions{LabelSelector: d.cfg.LabelSelector})
+				if err != nil {
+					return fmt.Errorf("Error listing nodes of cluster %s: %w", kc.name, err)
+				}
+				level.Debug(log.With(d.logger, "component", "KubernetesNodesDiscovery")).Log("debug", fmt.Sprintf("found %d nodes in cluster %s", len(nodes.Items), kc.name))
+				mu.Lock()
+				defer mu.Unlock()
+				for _, node := range nodes.Items {
+					if group := d.createNodeGroup(kc.name, node); group != nil {
+						tgroups = append(tgroups, group)
+					}
+				}
+				return nil
+			})
+		}(kc)
+	}
+	err = eg.Wait()
+	return
+}
+
+// createNodeGroup returns a group with a target per InternalIP of the node, nil if it has none
+func (d *KubernetesNodesDiscovery) createNodeGroup(cluster string, node v1.Node) *targetgroup.Group {
+	tgroup := &targetgroup.Group{
+		Source:  cluster + "/" + node.Name,
+		Targets: make([]model.LabelSet, 0, 1),
+	}
+	for _, address := range node.Status.Addresses {
+		if address.Type != v1.NodeInternalIP {
+			continue
+		}
+		target := address.Address
+		if d.cfg.Port > 0 {
+			target = net.JoinHostPort(target, strconv.Itoa(d.cfg.Port))
+		}
+		tgroup.Targets = append(tgroup.Targets, model.LabelSet{model.AddressLabel: model.LabelValue(target)})
+	}
+	if len(tgroup.Targets) == 0 {
+		level.Debug(log.With(d.logger, "component", "KubernetesNodesDiscovery")).Log("debug", fmt.Sprintf("ignoring node %s of cluster %s: no InternalIP", node.Name, cluster))
+		return nil
+	}
+
+	labels := model.LabelSet{
+		model.LabelName("server_name"):   model.LabelValue(node.Name),
+		model.LabelName("ready"):         model.LabelValue(nodeReady(node)),
+		model.LabelName("metrics_label"): model.LabelValue(d.metricsLabel),
+	}
+	if cluster != "" {
+		labels[model.LabelName("cluster")] = model.LabelValue(cluster)
+	}
+	if roles := nodeRoles(node); roles != "" {
+		labels[model.LabelName("role")] = model.LabelValue(roles)
+	}
+	zone := node.Labels[zoneLabel]
+	if zone == "" {
+		zone = node.Labels[zoneLabelBeta]
+	}
+	if zone != "" {
+		labels[model.LabelName("zone")] = model.LabelValue(zone)
+	}
+	// The openstack provider id is the instance uuid, which the ironic discovery uses as server_id as well
+	if strings.HasPrefix(node.Spec.ProviderID, "openstack://") {
+		labels[model.LabelName("server_id")] = model.LabelValue(node.Spec.ProviderID[strings.LastIndex(node.Spec.ProviderID, "/")+1:])
+	}
+	for name, value := range node.Labels {
+		labels[kubernetesLabelName("label_", name)] = model.LabelValue(value)
+	}
+	tgroup.Labels = labels.Merge(customLabels(d.cfg.CustomLabels))
+	return tgroup
+}
+
+// nodeRoles returns the roles of the node from its node-role.kubernetes.io/<role> and kubernetes.io/role labels,
+// comma separated
+func nodeRoles(node v1.Node) string {
+	var roles []string
+	for name, value := range node.Labels {
+		switch {
+		case strings.HasPrefix(name, nodeRoleLabelPrefix) && len(name) > len(nodeRoleLabelPrefix):
+			roles = append(roles, strings.TrimPrefix(name, nodeRoleLabelPrefix))
+		case name == nodeRoleLabel && value != "":
+			roles = append(roles, value)
+		}
+	}
+	return joinedValues(roles)
+}
+
+// nodeReady returns the status of the Ready condition: true, false or unknown
+func nodeReady(node v1.Node) string {
+	for _, c := range node.Status.Conditions {
+		if c.Type == v1.NodeReady {
+			return strings.ToLower(string(c.Status))
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,44 @@
+package discovery
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "k8s.io/client-go/pkg/api/v1"
+)
+
+func TestNodeRoles(t *testing.T) {
+	tests := []struct {
+		labels map[string]string
+		want   string
+	}{
+		{nil, ""},
+		{map[string]string{"node-role.kubernetes.io/master": ""}, "master"},
+		{map[string]string{"node-role.kubernetes.io/worker": "", "node-role.kubernetes.io/ingress": "true"}, "ingress,worker"},
+		{map[string]string{"kubernetes.io/role": "master", "node-role.kubernetes.io/master": ""}, "master"},
+		{map[string]string{"kubernetes.io/role": "", "node-role.kubernetes.io/": ""}, ""},
+		{map[string]string{"kubernetes.io/hostname": "node001"}, ""},
+	}
+	for _, tt := range tests {
+		node := v1.Node{ObjectMeta: metav1.ObjectMeta{Labels: tt.labels}}
+		if got := nodeRoles(node); got != tt.want {
+			t.Errorf("nodeRoles(%v) = %q, want %q", tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestJoinedValues(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{"b", "a", "b"}, "a,b"},
+		{[]string{"snmp"}, "snmp"},
+	}
+	for _, tt := range tests {
+		if got := joinedValues(tt.values); got != tt.want {
+			t.Errorf("joinedValues(%v) = %q, want %q", tt.values, got, tt.want)
+		}
+	}
+}
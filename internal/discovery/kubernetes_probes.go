@@ -0,0 +1,403 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/sapcc/atlas/pkg/adapter"
+	"github.com/sapcc/atlas/pkg/config"
+	"github.com/sapcc/atlas/pkg/errgroup"
+	"github.com/sapcc/atlas/pkg/writer"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	v1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const kubernetesProbesDiscovery = "kubernetes_probes"
+
+const (
+	defaultAnnotationPrefix = "atlas.sapcc.com"
+	defaultServiceModule    = "tcp_connect"
+	defaultIngressModule    = "http_2xx"
+)
+
+type (
+	KubernetesProbesDiscovery struct {
+		cfg             kubernetesProbesConfig
+		adapter         adapter.Adapter
+		clusters        []kubernetesClient
+		selector        k8slabels.Selector
+		refreshInterval int
+		logger          log.Logger
+		status          *Status
+		outputFile      string
+		metricsLabel    string
+	}
+
+	kubernetesProbesConfig struct {
+		Clusters []kubernetesCluster `yaml:"clusters"`
+		// Namespaces to look for services and ingresses in, all if empty
+		Namespaces []string `yaml:"namespaces"`
+		// LabelSelector opts in the matching services and ingresses, in addition to the annotated ones
+		LabelSelector string `yaml:"label_selector"`
+		// AnnotationPrefix of the probe, module and path annotations, defaults to atlas.sapcc.com
+		AnnotationPrefix string            `yaml:"annotation_prefix"`
+		ServiceModule    string            `yaml:"service_module"`
+		IngressModule    string            `yaml:"ingress_module"`
+		RefreshInterval  int               `yaml:"refresh_interval"`
+		TargetsFileName  string            `yaml:"targets_file_name"`
+		MetricsLabel     string            `yaml:"metrics_label"`
+		CustomLabels     map[string]string `yaml:"custom_labels"`
+		ConfigmapName    string            `yaml:"configmap_name"`
+	}
+
+	// ingressList is the part of a networking.k8s.io/v1 (or extensions/v1beta1) ingress list needed for the probes.
+	// It is decoded by hand, because the kubernetes client only knows the extensions/v1beta1 ingresses.
+	ingressList struct {
+		Items []struct {
+			Metadata struct {
+				Name        string            `json:"name"`
+				Namespace   string            `json:"namespace"`
+				Labels      map[string]string `json:"labels"`
+				Annotations map[string]string `json:"annotations"`
+			} `json:"metadata"`
+			Spec struct {
+				TLS []struct {
+					Hosts []string `json:"hosts"`
+				} `json:"tls"`
+				Rules []struct {
+					Host string `json:"host"`
+				} `json:"rules"`
+			} `json:"spec"`
+		} `json:"items"`
+	}
+)
+
+func init() {
+	Register(kubernetesProbesDiscovery, NewKubernetesProbesDiscovery)
+}
+
+// NewKubernetesProbesDiscovery creates a new Kubernetes Probes Discovery
+func NewKubernetesProbesDiscovery(disc interface{}, ctx context.Context, opts config.Options, l log.Logger) (d Discovery, err error) {
+	var cfg kubernetesProbesConfig
+	if err := UnmarshalHandler(disc, &cfg, nil); err != nil {
+		return d, err
+	}
+	if cfg.AnnotationPrefix == "" {
+		cfg.AnnotationPrefix = defaultAnnotationPrefix
+	}
+	if cfg.ServiceModule == "" {
+		cfg.ServiceModule = defaultServiceModule
+	}
+	if cfg.IngressModule == "" {
+		cfg.IngressModule = defaultIngressModule
+	}
+	if len(cfg.Namespaces) == 0 {
+		cfg.Namespaces = []string{metav1.NamespaceAll}
+	}
+	var selector k8slabels.Selector
+	if cfg.LabelSelector != "" {
+		if selector, err = k8slabels.Parse(cfg.LabelSelector); err != nil {
+			return d, fmt.Errorf("invalid label_selector %s: %w", cfg.LabelSelector, err)
+		}
+	}
+	clusters, err := newKubernetesClients(cfg.Clusters)
+	if err != nil {
+		level.Error(log.With(l, "component", "KubernetesProbesDiscovery")).Log("err", err)
+		return d, err
+	}
+
+	var w writer.Writer
+	if cfg.ConfigmapName != "" {
+		w, err = writer.NewConfigMap(cfg.ConfigmapName, opts.NameSpace, l)
+	} else {
+		w, err = writer.NewFile(cfg.TargetsFileName, l)
+	}
+
+	a := adapter.NewPrometheus(ctx, cfg.TargetsFileName, w, l)
+
+	return &KubernetesProbesDiscovery{
+		cfg:             cfg,
+		adapter:         a,
+		clusters:        clusters,
+		selector:        selector,
+		refreshInterval: cfg.RefreshInterval,
+		logger:          l,
+		status:          &Status{Up: false, Targets: make(map[string]int)},
+		outputFile:      cfg.TargetsFileName,
+		metricsLabel:    cfg.MetricsLabel,
+	}, nil
+}
+
+func (d *KubernetesProbesDiscovery) Run(ctx context.Context, ch chan<- []*targetgroup.Group) {
+	for c := time.Tick(time.Duration(d.refreshInterval) * time.Second); ; {
+		tgs, err := d.loadProbes()
+		d.status.Lock()
+		d.status.Up = err == nil
+		d.status.Unlock()
+		if err == nil {
+			level.Debug(log.With(d.logger, "component", "KubernetesProbesDiscovery")).Log("debug", "Done Loading Services and Ingresses")
+			ch <- tgs
+		} else {
+			level.Error(log.With(d.logger, "component", "KubernetesProbesDiscovery")).Log("error", err)
+		}
+		// Wait for ticker or exit when ctx is closed.
+		select {
+		case <-c:
+			continue
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *KubernetesProbesDiscovery) GetAdapter() adapter.Adapter {
+	return d.adapter
+}
+
+func (d *KubernetesProbesDiscovery) Up() bool {
+	return d.status.Up
+}
+
+func (d *KubernetesProbesDiscovery) Targets() map[string]int {
+	d.status.Targets = make(map[string]int)
+	setMetricsLabelAndValue(d.status.Targets, d.metricsLabel, d.adapter.GetNumberOfTargetsFor(d.metricsLabel))
+	return d.status.Targets
+}
+
+func (d *KubernetesProbesDiscovery) Lock() {
+	d.status.Lock()
+}
+
+func (d *KubernetesProbesDiscovery) Unlock() {
+	d.status.Unlock()
+}
+
+func (d *KubernetesProbesDiscovery) GetOutputFile() string {
+	return d.outputFile
+}
+
+func (d *KubernetesProbesDiscovery) GetName() string {
+	return kubernetesProbesDiscovery
+}
+
+// loadProbes lists the services and ingresses of all clusters and namespaces. A failing cluster fails the whole run.
+func (d *KubernetesProbesDiscovery) loadProbes() (tgroups []*targetgroup.Group, err error) {
+	var (
+		eg errgroup.Group
+		mu sync.Mutex
+	)
+	add := func(groups []*targetgroup.Group) {
+		mu.Lock()
+		defer mu.Unlock()
+		tgroups = append(tgroups, groups...)
+	}
+	for _, kc := range d.clusters {
+		for _, ns := range d.cfg.Namespaces {
+			func(kc kubernetesClient, ns string) {
+				eg.Go(func() error {
+					groups, err := d.loadServices(kc, ns)
+					if err != nil {
+						return err
+					}
+					add(groups)
+					return nil
+				})
+				eg.Go(func() error {
+					groups, err := d.loadIngresses(kc, ns)
+					if err != nil {
+						return err
+					}
+					add(groups)
+					return nil
+				})
+			}(kc, ns)
+		}
+	}
+	err = eg.Wait()
+	return
+}
+
+func (d *KubernetesProbesDiscovery) loadServices(kc kubernetesClient, ns string) (tgroups []*targetgroup.Group, err error) {
+	services, err := kc.client.CoreV1().Services(ns).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing services of cluster %s: %w", kc.name, err)
+	}
+	for _, svc := range services.Items {
+		if !d.optedIn(svc.Labels, svc.Annotations) {
+			continue
+		}
+		var hosts []string
+		switch svc.Spec.Type {
+		case v1.ServiceTypeLoadBalancer:
+			for _, ingress := range svc.Status.LoadBalancer.Ingress {
+				if ingress.IP != "" {
+					hosts = append(hosts, ingress.IP)
+				} else if ingress.Hostname != "" {
+					hosts = append(hosts, ingress.Hostname)
+				}
+			}
+		case v1.ServiceTypeExternalName:
+			hosts = append(hosts, svc.Spec.ExternalName)
+		default:
+			continue
+		}
+		if len(hosts) == 0 {
+			level.Debug(log.With(d.logger, "component", "KubernetesProbesDiscovery")).Log("debug", fmt.Sprintf("ignoring service %s/%s of cluster %s: no address", svc.Namespace, svc.Name, kc.name))
+			continue
+		}
+		ports := d.probePorts(svc.Spec.Ports, svc.Annotations)
+		if len(svc.Spec.Ports) > 0 && len(ports) == 0 {
+			level.Debug(log.With(d.logger, "component", "KubernetesProbesDiscovery")).Log("debug", fmt.Sprintf("ignoring service %s/%s of cluster %s: no tcp port", svc.Namespace, svc.Name, kc.name))
+			continue
+		}
+
+		var targets []model.LabelSet
+		for _, host := range hosts {
+			if len(ports) == 0 {
+				targets = append(targets, model.LabelSet{model.AddressLabel: model.LabelValue(host)})
+			}
+			for _, port := range ports {
+				targets = append(targets, model.LabelSet{
+					model.AddressLabel:           model.LabelValue(net.JoinHostPort(host, strconv.Itoa(int(port.Port)))),
+					model.LabelName("port_name"): model.LabelValue(port.Name),
+					model.LabelName("protocol"):  model.LabelValue(strings.ToLower(string(port.Protocol))),
+				})
+			}
+		}
+		labels := d.probeLabels(kc.name, svc.Namespace, svc.Annotations, d.cfg.ServiceModule)
+		labels[model.LabelName("service")] = model.LabelValue(svc.Name)
+		labels[model.LabelName("service_type")] = model.LabelValue(svc.Spec.Type)
+		tgroups = append(tgroups, &targetgroup.Group{
+			Source:  kc.name + "/" + svc.Namespace + "/service/" + svc.Name,
+			Labels:  labels.Merge(customLabels(d.cfg.CustomLabels)),
+			Targets: targets,
+		})
+	}
+	return
+}
+
+// loadIngresses emits an url per ingress host, https if the host is listed in the tls section.
+// The networking.k8s.io/v1 api is preferred, clusters older than 1.19 fall back to extensions/v1beta1.
+func (d *KubernetesProbesDiscovery) loadIngresses(kc kubernetesClient, ns string) (tgroups []*targetgroup.Group, err error) {
+	var list ingressList
+	for _, api := range []string{"/apis/networking.k8s.io/v1", "/apis/extensions/v1beta1"} {
+		path := api + "/ingresses"
+		if ns != metav1.NamespaceAll {
+			path = api + "/namespaces/" + ns + "/ingresses"
+		}
+		var data []byte
+		data, err = kc.client.CoreV1().RESTClient().Get().AbsPath(path).DoRaw()
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error listing ingresses of cluster %s: %w", kc.name, err)
+		}
+		if err = json.Unmarshal(data, &list); err != nil {
+			return nil, fmt.Errorf("Error decoding ingresses of cluster %s: %w", kc.name, err)
+		}
+		break
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error listing ingresses of cluster %s: %w", kc.name, err)
+	}
+
+	for _, ing := range list.Items {
+		meta := ing.Metadata
+		if !d.optedIn(meta.Labels, meta.Annotations) {
+			continue
+		}
+		tls := make(map[string]bool)
+		for _, t := range ing.Spec.TLS {
+			for _, host := range t.Hosts {
+				tls[host] = true
+			}
+		}
+		path := meta.Annotations[d.cfg.AnnotationPrefix+"/path"]
+		if path != "" && !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		var targets []model.LabelSet
+		seen := make(map[string]bool)
+		for _, rule := range ing.Spec.Rules {
+			// Rules without host or with a wildcard host match any (sub)domain, there is nothing to probe
+			if rule.Host == "" || strings.HasPrefix(rule.Host, "*") || seen[rule.Host] {
+				continue
+			}
+			seen[rule.Host] = true
+			scheme := "http"
+			if tls[rule.Host] {
+				scheme = "https"
+			}
+			targets = append(targets, model.LabelSet{model.AddressLabel: model.LabelValue(scheme + "://" + rule.Host + path)})
+		}
+		if len(targets) == 0 {
+			continue
+		}
+		labels := d.probeLabels(kc.name, meta.Namespace, meta.Annotations, d.cfg.IngressModule)
+		labels[model.LabelName("ingress")] = model.LabelValue(meta.Name)
+		tgroups = append(tgroups, &targetgroup.Group{
+			Source:  kc.name + "/" + meta.Namespace + "/ingress/" + meta.Name,
+			Labels:  labels.Merge(customLabels(d.cfg.CustomLabels)),
+			Targets: targets,
+		})
+	}
+	return
+}
+
+// probePorts returns the ports to probe. The default module probes tcp, so ports of other protocols are only probed
+// with the module set by the <prefix>/module annotation.
+func (d *KubernetesProbesDiscovery) probePorts(ports []v1.ServicePort, annotations map[string]string) []v1.ServicePort {
+	if annotations[d.cfg.AnnotationPrefix+"/module"] != "" {
+		return ports
+	}
+	tcp := make([]v1.ServicePort, 0, len(ports))
+	for _, port := range ports {
+		if port.Protocol == "" || port.Protocol == v1.ProtocolTCP {
+			tcp = append(tcp, port)
+		}
+	}
+	return tcp
+}
+
+// optedIn returns true if the object is annotated with <prefix>/probe: "true", or matches the label selector and
+// isn't annotated with <prefix>/probe: "false"
+func (d *KubernetesProbesDiscovery) optedIn(l, annotations map[string]string) bool {
+	switch annotations[d.cfg.AnnotationPrefix+"/probe"] {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return d.selector != nil && d.selector.Matches(k8slabels.Set(l))
+}
+
+// probeLabels returns the labels shared by services and ingresses, with the blackbox module of the
+// <prefix>/module annotation or else the default module
+func (d *KubernetesProbesDiscovery) probeLabels(cluster, namespace string, annotations map[string]string, module string) model.LabelSet {
+	if m := annotations[d.cfg.AnnotationPrefix+"/module"]; m != "" {
+		module = m
+	}
+	labels := model.LabelSet{
+		model.LabelName("namespace"):      model.LabelValue(namespace),
+		model.LabelName("__param_module"): model.LabelValue(module),
+		model.LabelName("module"):         model.LabelValue(module),
+		model.LabelName("metrics_label"):  model.LabelValue(d.metricsLabel),
+	}
+	if cluster != "" {
+		labels[model.LabelName("cluster")] = model.LabelValue(cluster)
+	}
+	return labels
+}
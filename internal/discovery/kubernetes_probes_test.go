@@ -0,0 +1,32 @@
+package discovery
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/client-go/pkg/api/v1"
+)
+
+func TestProbePorts(t *testing.T) {
+	d := &KubernetesProbesDiscovery{cfg: kubernetesProbesConfig{AnnotationPrefix: defaultAnnotationPrefix}}
+	http := v1.ServicePort{Name: "http", Port: 80}
+	https := v1.ServicePort{Name: "https", Port: 443, Protocol: v1.ProtocolTCP}
+	dns := v1.ServicePort{Name: "dns", Port: 53, Protocol: v1.ProtocolUDP}
+	tests := []struct {
+		name        string
+		ports       []v1.ServicePort
+		annotations map[string]string
+		want        []v1.ServicePort
+	}{
+		{"tcp only", []v1.ServicePort{http, https, dns}, nil, []v1.ServicePort{http, https}},
+		{"udp only", []v1.ServicePort{dns}, nil, []v1.ServicePort{}},
+		{"module annotation", []v1.ServicePort{http, dns}, map[string]string{"atlas.sapcc.com/module": "dns_udp"}, []v1.ServicePort{http, dns}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.probePorts(tt.ports, tt.annotations); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("probePorts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package discovery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/sapcc/atlas/pkg/errgroup"
+	"github.com/sapcc/atlas/pkg/netbox"
+)
+
+// sync loads every netbox, and returns their combined groups. The groups are only sent if all of them succeed.
+func (sd *NetboxDiscovery) sync() (tgroups []*targetgroup.Group, err error) {
+	var eg errgroup.Group
+	for _, nd := range sd.netboxes() {
+		eg.Go(nd.syncInstance)
+	}
+	if err = eg.Wait(); err != nil {
+		return
+	}
+	return sd.allGroups(), nil
+}
+
+// syncInstance loads all queries, or only applies the netbox changes since the last run when full_resync_interval
+// is set and the last full load isn't older than that.
+func (sd *NetboxDiscovery) syncInstance() error {
+	resync := time.Duration(sd.cfg.FullResyncInterval) * time.Second
+	if resync <= 0 || sd.groups == nil || time.Since(sd.lastFullSync) >= resync {
+		return sd.fullSync()
+	}
+	return sd.incrementalSync()
+}
+
+func (sd *NetboxDiscovery) fullSync() (err error) {
+	var changeID int64
+	if sd.cfg.FullResyncInterval > 0 {
+		// Read the position in the change log first, so that changes made during the load are applied by the next run
+		if changeID, err = sd.netbox.LastObjectChangeID(); err != nil {
+			return fmt.Errorf("Error loading netbox change log: %w", err)
+		}
+	}
+	groups, err := sd.loadData()
+	if err != nil {
+		return
+	}
+	sd.groups = groups
+	sd.lastFullSync = time.Now()
+	sd.lastChangeID = changeID
+	return
+}
+
+// incrementalSync reloads the devices and vms changed since the last run in all queries that can be patched,
+// and all queries which watch one of the changed object types.
+// Changes of other objects (e.g. sites or tenants) only show up with the next full sync.
+func (sd *NetboxDiscovery) incrementalSync() (err error) {
+	changes, err := sd.netbox.ObjectChanges(sd.lastChangeID)
+	if err != nil {
+		return fmt.Errorf("Error loading netbox change log: %w", err)
+	}
+	if len(changes) == 0 {
+		return
+	}
+	// On errors nothing is applied, the same changes are tried again with the next run
+	if err = sd.applyChanges(changes); err != nil {
+		return
+	}
+	for _, c := range changes {
+		if c.ID > sd.lastChangeID {
+			sd.lastChangeID = c.ID
+		}
+	}
+	return
+}
+
+// applyChanges reloads the queries affected by the changes. The groups are only replaced if all reloads succeed.
+func (sd *NetboxDiscovery) applyChanges(changes []netbox.ObjectChange) (err error) {
+	devices, vms, types, err := sd.changedObjects(changes)
+	if err != nil {
+		return
+	}
+	level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", fmt.Sprintf("applying %d netbox changes: %d devices, %d vms", len(changes), len(devices), len(vms)))
+	changed := make([]int64, 0, len(devices))
+	for id := range devices {
+		changed = append(changed, id)
+	}
+	sd.netbox.InvalidateDevices(changed)
+
+	var eg errgroup.Group
+	var mu sync.Mutex
+	updated := make(map[string][]*targetgroup.Group)
+	sd.labels = newNetboxLabels(sd.netbox)
+	for _, q := range sd.queries() {
+		ids := devices
+		if q.vms {
+			ids = vms
+		}
+		if !q.watches(types) && (q.patch == nil || len(ids) == 0) {
+			continue
+		}
+		func(q netboxQuery, ids map[int64]bool) {
+			eg.Go(func() error {
+				var groups []*targetgroup.Group
+				var err error
+				if q.watches(types) {
+					groups, err = collectGroups(q.load)
+				} else {
+					groups, err = sd.patchQuery(q, ids)
+				}
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				updated[q.name] = groups
+				mu.Unlock()
+				return nil
+			})
+		}(q, ids)
+	}
+	if err = eg.Wait(); err != nil {
+		return
+	}
+	for name, groups := range updated {
+		sd.groups[name] = groups
+	}
+	return
+}
+
+// patchQuery replaces the groups of the devices (or vms) with the ids by freshly loaded ones
+func (sd *NetboxDiscovery) patchQuery(q netboxQuery, ids map[int64]bool) (tgroups []*targetgroup.Group, err error) {
+	for _, group := range sd.groups[q.name] {
+		if !ids[groupServerID(group)] {
+			tgroups = append(tgroups, group)
+		}
+	}
+	for id := range ids {
+		if sd.cfg.RateLimiter > 0 {
+			<-sd.rateLimiter.C
+		}
+		groups, err := collectGroups(func(ch chan<- []*targetgroup.Group) error { return q.patch(id, ch) })
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			if groupServerID(group) == id {
+				tgroups = append(tgroups, group)
+			}
+		}
+	}
+	return
+}
+
+// changedObjects returns the ids of the devices and vms affected by the changes, and the changed object types.
+// Changes of nothing but the write back fields are left out.
+func (sd *NetboxDiscovery) changedObjects(changes []netbox.ObjectChange) (devices, vms map[int64]bool, types map[string]bool, err error) {
+	devices = make(map[int64]bool)
+	vms = make(map[int64]bool)
+	types = make(map[string]bool)
+	for _, c := range changes {
+		// The custom fields written back by atlas don't change any target
+		if c.OnlyCustomFields(sd.cfg.WriteBack.fields()...) {
+			continue
+		}
+		types[c.ChangedObjectType] = true
+		switch c.ChangedObjectType {
+		case "dcim.device":
+			devices[c.ChangedObjectID] = true
+		case "virtualization.virtualmachine":
+			vms[c.ChangedObjectID] = true
+		case "dcim.interface", "virtualization.vminterface":
+			for _, id := range c.RelatedIDs("device") {
+				devices[id] = true
+			}
+			for _, id := range c.RelatedIDs("virtual_machine") {
+				vms[id] = true
+			}
+		case "ipam.ipaddress":
+			// Netbox up to 2.8 serializes the interface, newer versions the assigned object
+			for _, intfID := range append(c.RelatedIDs("interface"), c.RelatedIDs("assigned_object_id")...) {
+				deviceIDs, vmIDs, err := sd.netbox.InterfaceOwners(intfID)
+				if err != nil {
+					return devices, vms, types, fmt.Errorf("Error loading owner of interface %d: %w", intfID, err)
+				}
+				for _, id := range deviceIDs {
+					devices[id] = true
+				}
+				for _, id := range vmIDs {
+					vms[id] = true
+				}
+			}
+		}
+	}
+	return
+}
+
+// allGroups returns the deduplicated groups of all netboxes, in a stable order
+func (sd *NetboxDiscovery) allGroups() []*targetgroup.Group {
+	return sd.deduplicate(sd.allQueryGroups())
+}
+
+func (q netboxQuery) watches(types map[string]bool) bool {
+	for t := range types {
+		for _, w := range q.watch {
+			if strings.HasPrefix(t, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func groupServerID(group *targetgroup.Group) int64 {
+	id, _ := strconv.ParseInt(string(group.Labels[model.LabelName("server_id")]), 10, 64)
+	return id
+}
+
+func idParam(id int64) *string {
+	s := strconv.FormatInt(id, 10)
+	return &s
+}
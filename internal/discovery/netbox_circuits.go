@@ -0,0 +1,108 @@
+package discovery
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/netbox-community/go-netbox/netbox/models"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"gopkg.in/yaml.v2"
+)
+
+func (sd *NetboxDiscovery) loadCircuits(q circuit, groupsCh chan<- []*targetgroup.Group) (err error) {
+	var tgroups []*targetgroup.Group
+	circuits, err := sd.netbox.CircuitsByParams(q.CircuitsCircuitsListParams, q.RawQuery)
+	if err != nil {
+		dout, _ := yaml.Marshal(q.CircuitsCircuitsListParams)
+		return fmt.Errorf("Error loading circuits / Query=%s: %w", string(dout), err)
+	}
+	level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", fmt.Sprintf("found %d circuits", len(circuits)))
+	for _, c := range circuits {
+		terminations := map[string]*models.CircuitCircuitTermination{
+			"A": c.Terminationa,
+			"Z": c.Terminationz,
+		}
+		connected := 0
+		for side, t := range terminations {
+			// Only terminations cabled to one of our device interfaces can be probed
+			if t == nil || t.ConnectedEndpoint == nil || t.ConnectedEndpoint.Device == nil {
+				continue
+			}
+			connected++
+			if sd.cfg.RateLimiter > 0 {
+				<-sd.rateLimiter.C
+			}
+			groups, err := sd.createCircuitGroups(q.entryParams, c, side, t)
+			if err != nil {
+				level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Ignoring circuit: %s termination %s. Error: %s", *c.Cid, side, err.Error()))
+				continue
+			}
+			tgroups = append(tgroups, groups...)
+		}
+		if connected == 0 {
+			level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", fmt.Sprintf("circuit %s has no connected device interface", *c.Cid))
+		}
+	}
+	groupsCh <- tgroups
+	return
+}
+
+func (sd *NetboxDiscovery) createCircuitGroups(p entryParams, c models.Circuit, side string, t *models.CircuitCircuitTermination) (tgroups []*targetgroup.Group, err error) {
+	intf := t.ConnectedEndpoint
+	deviceID := strconv.FormatInt(intf.Device.ID, 10)
+	interfaceName := ""
+	if intf.Name != nil {
+		interfaceName = *intf.Name
+	}
+	ips, err := sd.netbox.InterfaceIPs(deviceID, intf.ID, interfaceName)
+	if err != nil {
+		return
+	}
+	if len(ips) == 0 {
+		return tgroups, fmt.Errorf("no ips on interface %s of device %s", interfaceName, deviceID)
+	}
+
+	labels := model.LabelSet{
+		model.LabelName("circuit_id"):    model.LabelValue(*c.Cid),
+		model.LabelName("term_side"):     model.LabelValue(side),
+		model.LabelName("server_id"):     model.LabelValue(deviceID),
+		model.LabelName("metrics_label"): model.LabelValue(p.MetricsLabel),
+	}
+	if c.Provider != nil && c.Provider.Slug != nil {
+		labels[model.LabelName("provider")] = model.LabelValue(*c.Provider.Slug)
+	}
+	if c.Type != nil && c.Type.Slug != nil {
+		labels[model.LabelName("circuit_type")] = model.LabelValue(*c.Type.Slug)
+	}
+	if c.CommitRate != nil {
+		labels[model.LabelName("commit_rate")] = model.LabelValue(strconv.FormatInt(*c.CommitRate, 10))
+	}
+	if c.Status != nil && c.Status.Value != nil {
+		labels[model.LabelName("status")] = model.LabelValue(*c.Status.Value)
+	}
+	if c.Tenant != nil && c.Tenant.Slug != nil {
+		labels[model.LabelName("tenant")] = model.LabelValue(*c.Tenant.Slug)
+	}
+	if t.Site != nil && t.Site.Slug != nil {
+		labels[model.LabelName("site")] = model.LabelValue(*t.Site.Slug)
+	}
+	if intf.Device.Name != nil {
+		labels[model.LabelName("server_name")] = model.LabelValue(*intf.Device.Name)
+	}
+	labels = labels.Merge(customLabels(p.CustomLabels))
+
+	for _, ip := range ips {
+		ipLabels := labels.Clone()
+		ipLabels[model.LabelName("interface")] = model.LabelValue(ip.Interface)
+		ipLabels[model.LabelName("address_family")] = model.LabelValue(ip.Family)
+		tgroups = append(tgroups, &targetgroup.Group{
+			Source:  fmt.Sprintf("circuit/%d/%s/%s", c.ID, side, ip.Address),
+			Labels:  ipLabels,
+			Targets: []model.LabelSet{{model.AddressLabel: model.LabelValue(ip.Address)}},
+		})
+	}
+	return
+}
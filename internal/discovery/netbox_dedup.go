@@ -0,0 +1,208 @@
+package discovery
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+const (
+	// dedupFirstQuery keeps the target of the first configured query
+	dedupFirstQuery = "first_query"
+	// dedupVirtualChassis keeps the target of the virtual chassis master, or else of the first query
+	dedupVirtualChassis = "virtual_chassis_master"
+	// dedupMergeLabels keeps one target with the labels of all duplicates, differing values comma separated
+	dedupMergeLabels = "merge_labels"
+
+	// vcMasterLabel marks the groups of virtual chassis members. It is removed before the groups are sent.
+	vcMasterLabel = model.LabelName("__virtual_chassis_master")
+)
+
+var droppedDuplicates = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "atlas_netbox_dropped_duplicate_targets",
+	Help: "Number of duplicate netbox targets dropped by the last deduplication",
+}, []string{"policy", "metrics_label"})
+
+func init() {
+	prometheus.MustRegister(droppedDuplicates)
+}
+
+// duplicateTarget is the target with the index target in group, found by the query with the index query
+type duplicateTarget struct {
+	query  int
+	group  *targetgroup.Group
+	target int
+}
+
+// deduplicate flattens the groups of all queries (in configured order), keeping only one of the targets with the same
+// address, job and parameters, as selected by the deduplicate policy
+func (sd *NetboxDiscovery) deduplicate(queries []queryGroups) (tgroups []*targetgroup.Group) {
+	policy := sd.cfg.Deduplicate
+	dropped, merged := sd.duplicateTargets(queries)
+	counts := make(map[string]int)
+	for _, q := range queries {
+		for _, group := range q.groups {
+			if dropped[group] == nil && merged[group] == nil {
+				tgroups = append(tgroups, withoutVCMaster(group))
+				continue
+			}
+			rest := &targetgroup.Group{Source: group.Source, Labels: group.Labels}
+			for i, target := range group.Targets {
+				switch {
+				case dropped[group][i] != nil:
+					counts[string(group.Labels[model.LabelName("metrics_label")])]++
+					level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", fmt.Sprintf("dropping duplicate target %s of %s, keeping %s",
+						target[model.AddressLabel], group.Labels[model.LabelName("server_name")], dropped[group][i].Labels[model.LabelName("server_name")]))
+				case merged[group][i] != nil:
+					// The merged labels only apply to this target, so it gets its own group
+					source := group.Source
+					if len(group.Targets) > 1 {
+						source += "/" + string(target[model.AddressLabel])
+					}
+					tgroups = append(tgroups, withoutVCMaster(&targetgroup.Group{
+						Source:  source,
+						Labels:  merged[group][i],
+						Targets: []model.LabelSet{target},
+					}))
+				default:
+					rest.Targets = append(rest.Targets, target)
+				}
+			}
+			if len(rest.Targets) > 0 {
+				tgroups = append(tgroups, withoutVCMaster(rest))
+			}
+		}
+	}
+	// The gauge holds the duplicates of this run only, metrics labels without duplicates left are removed
+	droppedDuplicates.Reset()
+	for metricsLabel, count := range counts {
+		droppedDuplicates.WithLabelValues(policy, metricsLabel).Set(float64(count))
+	}
+	return
+}
+
+// duplicateTargets selects the targets the deduplicate policy drops. dropped holds the group of the kept target
+// by the index of every dropped target per group, merged the labels of kept targets with merged duplicates.
+func (sd *NetboxDiscovery) duplicateTargets(queries []queryGroups) (dropped map[*targetgroup.Group]map[int]*targetgroup.Group, merged map[*targetgroup.Group]map[int]model.LabelSet) {
+	policy := sd.cfg.Deduplicate
+	dropped = make(map[*targetgroup.Group]map[int]*targetgroup.Group)
+	merged = make(map[*targetgroup.Group]map[int]model.LabelSet)
+	if policy == "" {
+		return
+	}
+
+	var keys []string
+	targets := make(map[string][]duplicateTarget)
+	for q, qg := range queries {
+		for _, group := range qg.groups {
+			for i, target := range group.Targets {
+				key := targetKey(group, target)
+				if _, ok := targets[key]; !ok {
+					keys = append(keys, key)
+				}
+				targets[key] = append(targets[key], duplicateTarget{query: q, group: group, target: i})
+			}
+		}
+	}
+
+	for _, key := range keys {
+		dups := targets[key]
+		if len(dups) < 2 {
+			continue
+		}
+		// Targets of the same query are found in random order, the lower server id wins
+		sort.SliceStable(dups, func(i, j int) bool {
+			if dups[i].query != dups[j].query {
+				return dups[i].query < dups[j].query
+			}
+			return groupServerID(dups[i].group) < groupServerID(dups[j].group)
+		})
+		kept := 0
+		if policy == dedupVirtualChassis {
+			for i, d := range dups {
+				if d.group.Labels[vcMasterLabel] == "true" {
+					kept = i
+					break
+				}
+			}
+		}
+		for i, d := range dups {
+			if i == kept {
+				continue
+			}
+			if dropped[d.group] == nil {
+				dropped[d.group] = make(map[int]*targetgroup.Group)
+			}
+			dropped[d.group][d.target] = dups[kept].group
+		}
+		if policy == dedupMergeLabels {
+			k := dups[kept]
+			if merged[k.group] == nil {
+				merged[k.group] = make(map[int]model.LabelSet)
+			}
+			merged[k.group][k.target] = mergeLabels(dups, kept)
+		}
+	}
+	return
+}
+
+// targetKey identifies a target by its address, job and the parameters of the scrape (labels starting with __)
+func targetKey(group *targetgroup.Group, target model.LabelSet) string {
+	labels := group.Labels.Merge(target)
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if name == vcMasterLabel {
+			continue
+		}
+		if strings.HasPrefix(string(name), "__") || name == model.JobLabel {
+			names = append(names, string(name))
+		}
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+"="+string(labels[model.LabelName(name)]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// mergeLabels returns the group labels of all duplicates. Labels with differing values get all values, comma separated,
+// except for the labels identifying the object, which are the ones of the kept target.
+func mergeLabels(dups []duplicateTarget, kept int) model.LabelSet {
+	values := make(map[string][]string)
+	for _, d := range dups {
+		for name, value := range d.group.Labels {
+			values[string(name)] = append(values[string(name)], string(value))
+		}
+	}
+	joined := make(map[string]string, len(values))
+	for name, v := range values {
+		setJoinedLabel(joined, name, v)
+	}
+	labels := make(model.LabelSet, len(joined))
+	for name, value := range joined {
+		labels[model.LabelName(name)] = model.LabelValue(value)
+	}
+	for _, name := range []model.LabelName{"server_id", "metrics_label"} {
+		if value, ok := dups[kept].group.Labels[name]; ok {
+			labels[name] = value
+		}
+	}
+	return labels
+}
+
+// withoutVCMaster returns the group without the virtual chassis marker label
+func withoutVCMaster(group *targetgroup.Group) *targetgroup.Group {
+	if _, ok := group.Labels[vcMasterLabel]; !ok {
+		return group
+	}
+	labels := group.Labels.Clone()
+	delete(labels, vcMasterLabel)
+	return &targetgroup.Group{Source: group.Source, Labels: labels, Targets: group.Targets}
+}
@@ -0,0 +1,117 @@
+package discovery
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+func TestTargetKey(t *testing.T) {
+	group := &targetgroup.Group{Labels: model.LabelSet{"job": "snmp", "__param_module": "switch", "server_name": "sw1", vcMasterLabel: "true"}}
+	target := model.LabelSet{model.AddressLabel: "10.0.0.1"}
+	want := "__address__=10.0.0.1,__param_module=switch,job=snmp"
+	if got := targetKey(group, target); got != want {
+		t.Errorf("targetKey() = %q, want %q", got, want)
+	}
+
+	other := &targetgroup.Group{Labels: model.LabelSet{"job": "snmp", "__param_module": "switch", "server_name": "sw2"}}
+	if targetKey(group, target) != targetKey(other, target) {
+		t.Errorf("targets differing in plain labels have different keys")
+	}
+	other.Labels["__param_module"] = "router"
+	if targetKey(group, target) == targetKey(other, target) {
+		t.Errorf("targets differing in parameters have the same key")
+	}
+}
+
+func TestMergeLabels(t *testing.T) {
+	dups := []duplicateTarget{
+		{group: &targetgroup.Group{Labels: model.LabelSet{"server_id": "1", "metrics_label": "snmp", "role": "switch", "site": "de1"}}},
+		{group: &targetgroup.Group{Labels: model.LabelSet{"server_id": "2", "metrics_label": "ipmi", "role": "router", "site": "de1", "rack": "r1"}}},
+	}
+	want := model.LabelSet{"server_id": "2", "metrics_label": "ipmi", "role": "router,switch", "site": "de1", "rack": "r1"}
+	if got := mergeLabels(dups, 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeLabels() = %v, want %v", got, want)
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	group := func(id, name string, vcMaster bool, addresses ...string) *targetgroup.Group {
+		g := &targetgroup.Group{
+			Source: name,
+			Labels: model.LabelSet{"server_id": model.LabelValue(id), "server_name": model.LabelValue(name), "job": "snmp"},
+		}
+		if vcMaster {
+			g.Labels[vcMasterLabel] = "true"
+		}
+		for _, a := range addresses {
+			g.Targets = append(g.Targets, model.LabelSet{model.AddressLabel: model.LabelValue(a)})
+		}
+		return g
+	}
+	queries := func() []queryGroups {
+		return []queryGroups{
+			{groups: []*targetgroup.Group{group("2", "sw2", false, "10.0.0.1", "10.0.0.2")}},
+			{groups: []*targetgroup.Group{group("3", "sw3", true, "10.0.0.1"), group("1", "sw1", false, "10.0.0.3")}},
+		}
+	}
+	// targets returns the addresses by group source
+	targets := func(groups []*targetgroup.Group) map[string][]string {
+		got := make(map[string][]string)
+		for _, g := range groups {
+			if _, ok := g.Labels[vcMasterLabel]; ok {
+				t.Errorf("%s: virtual chassis label not removed", g.Source)
+			}
+			for _, target := range g.Targets {
+				got[g.Source] = append(got[g.Source], string(target[model.AddressLabel]))
+			}
+		}
+		return got
+	}
+
+	tests := []struct {
+		policy string
+		want   map[string][]string
+	}{
+		{
+			policy: "",
+			want:   map[string][]string{"sw2": {"10.0.0.1", "10.0.0.2"}, "sw3": {"10.0.0.1"}, "sw1": {"10.0.0.3"}},
+		},
+		{
+			policy: dedupFirstQuery,
+			want:   map[string][]string{"sw2": {"10.0.0.1", "10.0.0.2"}, "sw1": {"10.0.0.3"}},
+		},
+		{
+			policy: dedupVirtualChassis,
+			want:   map[string][]string{"sw2": {"10.0.0.2"}, "sw3": {"10.0.0.1"}, "sw1": {"10.0.0.3"}},
+		},
+		{
+			policy: dedupMergeLabels,
+			want:   map[string][]string{"sw2/10.0.0.1": {"10.0.0.1"}, "sw2": {"10.0.0.2"}, "sw1": {"10.0.0.3"}},
+		},
+	}
+	for _, tt := range tests {
+		name := tt.policy
+		if name == "" {
+			name = "none"
+		}
+		t.Run(name, func(t *testing.T) {
+			sd := &NetboxDiscovery{cfg: netboxConfig{Deduplicate: tt.policy}, logger: log.NewNopLogger()}
+			groups := sd.deduplicate(queries())
+			if got := targets(groups); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("targets = %v, want %v", got, tt.want)
+			}
+			if tt.policy != dedupMergeLabels {
+				return
+			}
+			for _, g := range groups {
+				if g.Source == "sw2/10.0.0.1" && (g.Labels["server_name"] != "sw2,sw3" || g.Labels["server_id"] != "2") {
+					t.Errorf("merged labels %v", g.Labels)
+				}
+			}
+		})
+	}
+}
@@ -35,17 +35,40 @@ type (
 		outputFile      string
 		cfg             netboxConfig
 		rateLimiter     *time.Ticker
+		labels          *netboxLabels
+		groups          map[string][]*targetgroup.Group
+		lastFullSync    time.Time
+		lastChangeID    int64
+		webhooks        chan webhookChange
+		// instance is the netbox_instance label value, only set if several netboxes are configured
+		instance  string
+		instances []*NetboxDiscovery
+		// writtenDevices and writtenVMs hold the field values written back to the objects, by id
+		writtenDevices map[int64]writtenObject
+		writtenVMs     map[int64]writtenObject
 	}
 
 	netboxConfig struct {
-		RefreshInterval int            `yaml:"refresh_interval"`
-		NetboxHost      string         `yaml:"netbox_host"`
-		NetboxAPIToken  string         `yaml:"netbox_api_token"`
-		RateLimiter     time.Duration  `yaml:"rate_limit"`
-		TargetsFileName string         `yaml:"targets_file_name"`
-		DCIM            dcim           `yaml:"dcim"`
-		Virtualization  virtualization `yaml:"virtualization"`
-		ConfigmapName   string         `yaml:"configmap_name"`
+		RefreshInterval    int                 `yaml:"refresh_interval"`
+		FullResyncInterval int                 `yaml:"full_resync_interval"`
+		NetboxHost         string              `yaml:"netbox_host"`
+		NetboxAPIToken     string              `yaml:"netbox_api_token"`
+		NetboxClient       netbox.ClientConfig `yaml:"netbox_client"`
+		Instance           string              `yaml:"instance"`
+		Instances          []netboxInstance    `yaml:"instances"`
+		RateLimiter        time.Duration       `yaml:"rate_limit"`
+		TargetsFileName    string              `yaml:"targets_file_name"`
+		DCIM               dcim                `yaml:"dcim"`
+		Virtualization     virtualization      `yaml:"virtualization"`
+		IPAM               ipamQueries         `yaml:"ipam"`
+		Circuits           circuitQueries      `yaml:"circuits"`
+		Power              power               `yaml:"power"`
+		GraphQL            []graphqlQuery      `yaml:"graphql"`
+		CacheTTL           netbox.CacheTTL     `yaml:"cache_ttl"`
+		WebhookSecret      string              `yaml:"webhook_secret"`
+		Deduplicate        string              `yaml:"deduplicate"`
+		WriteBack          writeBackConfig     `yaml:"write_back"`
+		ConfigmapName      string              `yaml:"configmap_name"`
 	}
 
 	configValues struct {
@@ -59,6 +82,13 @@ func init() {
 	Register(netboxDiscovery, NewNetboxDiscovery)
 }
 
+// hasQueries reports whether any query of the main netbox is configured
+func (c netboxConfig) hasQueries() bool {
+	return len(c.DCIM.Devices) > 0 || len(c.DCIM.Interfaces) > 0 || len(c.DCIM.Racks) > 0 || len(c.Virtualization.VMs) > 0 ||
+		len(c.IPAM.IPAddresses) > 0 || len(c.IPAM.Prefixes) > 0 || len(c.IPAM.Services) > 0 ||
+		len(c.Circuits.Circuits) > 0 || len(c.Power.PDUs) > 0 || len(c.GraphQL) > 0
+}
+
 //NewNetboxDiscovery creates
 func NewNetboxDiscovery(disc interface{}, ctx context.Context, opts config.Options, l log.Logger) (d Discovery, err error) {
 	var cfg netboxConfig
@@ -67,11 +97,33 @@ func NewNetboxDiscovery(disc interface{}, ctx context.Context, opts config.Optio
 		return nil, err
 	}
 
-	nClient, err := netbox.New(cfg.NetboxHost, cfg.NetboxAPIToken)
-	if err != nil {
-		return nil, err
+	switch cfg.Deduplicate {
+	case "", dedupFirstQuery, dedupVirtualChassis, dedupMergeLabels:
+	default:
+		return nil, fmt.Errorf("invalid deduplicate policy %s", cfg.Deduplicate)
+	}
+	for _, g := range cfg.GraphQL {
+		if len(g.RawQuery) > 0 {
+			return nil, fmt.Errorf("raw_query is not supported by graphql queries, filter in the query instead")
+		}
 	}
 
+	// netbox_host may be left out if only instances are configured
+	if cfg.NetboxHost == "" && len(cfg.Instances) > 0 && cfg.hasQueries() {
+		return nil, fmt.Errorf("netbox queries without netbox_host, only the queries of instances can be used without it")
+	}
+	var nClient *netbox.Netbox
+	if cfg.NetboxHost != "" || len(cfg.Instances) == 0 {
+		nClient, err = netbox.NewWithConfig(cfg.NetboxHost, cfg.NetboxAPIToken, cfg.NetboxClient)
+		if err != nil {
+			return nil, err
+		}
+		nClient.SetCacheTTL(cfg.CacheTTL)
+	}
+	if len(cfg.Instances) > 0 && cfg.Instance == "" {
+		cfg.Instance = cfg.NetboxHost
+	}
+	instances, err := newNetboxInstances(cfg, opts.Region, l)
 	if err != nil {
 		return d, err
 	}
@@ -94,6 +146,9 @@ func NewNetboxDiscovery(disc interface{}, ctx context.Context, opts config.Optio
 		status:          &Status{Up: false, Targets: make(map[string]int)},
 		outputFile:      cfg.TargetsFileName,
 		cfg:             cfg,
+		webhooks:        make(chan webhookChange, 100),
+		instance:        cfg.Instance,
+		instances:       instances,
 	}, err
 
 }
@@ -104,12 +159,19 @@ func (sd *NetboxDiscovery) Run(ctx context.Context, ch chan<- []*targetgroup.Gro
 			sd.rateLimiter.Stop()
 		}
 	}()
+	// Requests still running when the discovery is stopped are aborted
+	for _, nd := range sd.netboxes() {
+		nd.netbox = nd.netbox.WithContext(ctx)
+	}
 	for c := time.Tick(time.Duration(sd.refreshInterval) * time.Second); ; {
 		level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", "Loading Netbox data")
 		if sd.cfg.RateLimiter > 0 && sd.rateLimiter == nil {
 			sd.rateLimiter = time.NewTicker(sd.cfg.RateLimiter * time.Millisecond)
+			for _, nd := range sd.instances {
+				nd.rateLimiter = sd.rateLimiter
+			}
 		}
-		tgs, err := sd.loadData()
+		tgs, err := sd.sync()
 		if err == nil {
 			level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", "netbox data loaded")
 			sd.status.Lock()
@@ -120,6 +182,11 @@ func (sd *NetboxDiscovery) Run(ctx context.Context, ch chan<- []*targetgroup.Gro
 			} else {
 				ch <- tgs
 			}
+			if sd.cfg.WriteBack.MonitoredField != "" {
+				if err := sd.writeBack(); err != nil {
+					level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Error writing back to netbox: %w", err))
+				}
+			}
 		} else {
 			level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", "error loading netbox data "+err.Error())
 			merr, ok := err.(*multierror.Error)
@@ -144,66 +211,221 @@ func (sd *NetboxDiscovery) Run(ctx context.Context, ch chan<- []*targetgroup.Gro
 			sd.status.Up = false
 			sd.status.Unlock()
 		}
-		// Wait for ticker or exit when ctx is closed.
-		select {
-		case <-c:
-			continue
-		case <-ctx.Done():
-			return
+		// Wait for ticker or exit when ctx is closed. Webhook changes are applied in between.
+	wait:
+		for {
+			select {
+			case <-c:
+				break wait
+			case change := <-sd.webhooks:
+				sd.applyWebhooks(change, ch)
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
 }
 
-func (sd *NetboxDiscovery) loadData() (tgroups []*targetgroup.Group, err error) {
-	groupCh := make(chan []*targetgroup.Group, 0)
-	var eg errgroup.Group
-	for _, dcim := range sd.cfg.DCIM.Devices {
+// netboxQuery is a configured query entry together with its loader
+type netboxQuery struct {
+	name string
+	load func(groupsCh chan<- []*targetgroup.Group) error
+	// patch loads the groups of a single device (or vm) of the query. nil if the query can't be patched.
+	patch func(id int64, groupsCh chan<- []*targetgroup.Group) error
+	vms   bool
+	// devices is set if the server_id labels of the query are device ids (vms for vm ids)
+	devices bool
+	// watch are the changed object types (or their prefixes) that require a reload of the whole query
+	watch []string
+}
+
+// queries returns all configured query entries
+func (sd *NetboxDiscovery) queries() (q []netboxQuery) {
+	for i, dcim := range sd.cfg.DCIM.Devices {
 		func(dcim dcimDevice) {
-			eg.Go(func() error {
-				return sd.loadDcimDevices(dcim, groupCh)
+			q = append(q, netboxQuery{
+				name: fmt.Sprintf("dcim/devices/%d", i),
+				load: func(ch chan<- []*targetgroup.Group) error { return sd.loadDcimDevices(dcim, ch) },
+				patch: func(id int64, ch chan<- []*targetgroup.Group) error {
+					patched := dcim
+					patched.DcimDevicesListParams.ID = idParam(id)
+					return sd.loadDcimDevices(patched, ch)
+				},
+				devices: true,
 			})
 		}(dcim)
 	}
-	for _, vm := range sd.cfg.Virtualization.VMs {
+	for i, r := range sd.cfg.DCIM.Racks {
+		func(r dcimRack) {
+			q = append(q, netboxQuery{
+				name:    fmt.Sprintf("dcim/racks/%d", i),
+				load:    func(ch chan<- []*targetgroup.Group) error { return sd.loadDcimRacks(r, ch) },
+				watch:   []string{"dcim.", "ipam.ipaddress"},
+				devices: true,
+			})
+		}(r)
+	}
+	for i, intf := range sd.cfg.DCIM.Interfaces {
+		func(intf dcimInterface) {
+			q = append(q, netboxQuery{
+				name: fmt.Sprintf("dcim/interfaces/%d", i),
+				load: func(ch chan<- []*targetgroup.Group) error { return sd.loadDcimInterfaces(intf, ch) },
+				patch: func(id int64, ch chan<- []*targetgroup.Group) error {
+					patched := intf
+					patched.Devices.ID = idParam(id)
+					return sd.loadDcimInterfaces(patched, ch)
+				},
+				devices: true,
+			})
+		}(intf)
+	}
+	for i, vm := range sd.cfg.Virtualization.VMs {
 		func(vm virtualizationVM) {
-			eg.Go(func() error {
-				return sd.loadVirtualizationVMs(vm, groupCh)
+			q = append(q, netboxQuery{
+				name: fmt.Sprintf("virtualization/vm/%d", i),
+				load: func(ch chan<- []*targetgroup.Group) error { return sd.loadVirtualizationVMs(vm, ch) },
+				patch: func(id int64, ch chan<- []*targetgroup.Group) error {
+					patched := vm
+					patched.VirtualizationVirtualMachinesListParams.ID = idParam(id)
+					return sd.loadVirtualizationVMs(patched, ch)
+				},
+				vms: true,
 			})
 		}(vm)
 	}
-	go func() error {
-		if err = eg.Wait(); err != nil {
-			close(groupCh)
-			return err
-		}
-		close(groupCh)
-		return nil
-	}()
+	for i, ip := range sd.cfg.IPAM.IPAddresses {
+		func(ip ipamIPAddress) {
+			q = append(q, netboxQuery{
+				name:  fmt.Sprintf("ipam/ip_addresses/%d", i),
+				load:  func(ch chan<- []*targetgroup.Group) error { return sd.loadIPAddresses(ip, ch) },
+				watch: []string{"ipam."},
+			})
+		}(ip)
+	}
+	for i, prefix := range sd.cfg.IPAM.Prefixes {
+		func(prefix ipamPrefix) {
+			q = append(q, netboxQuery{
+				name:  fmt.Sprintf("ipam/prefixes/%d", i),
+				load:  func(ch chan<- []*targetgroup.Group) error { return sd.loadPrefixes(prefix, ch) },
+				watch: []string{"ipam."},
+			})
+		}(prefix)
+	}
+	for i, service := range sd.cfg.IPAM.Services {
+		func(service ipamService) {
+			q = append(q, netboxQuery{
+				name:  fmt.Sprintf("ipam/services/%d", i),
+				load:  func(ch chan<- []*targetgroup.Group) error { return sd.loadServices(service, ch) },
+				watch: []string{"ipam.", "dcim.device", "virtualization.virtualmachine"},
+			})
+		}(service)
+	}
+	for i, c := range sd.cfg.Circuits.Circuits {
+		func(c circuit) {
+			q = append(q, netboxQuery{
+				name:  fmt.Sprintf("circuits/circuits/%d", i),
+				load:  func(ch chan<- []*targetgroup.Group) error { return sd.loadCircuits(c, ch) },
+				watch: []string{"circuits.", "dcim.device", "dcim.interface", "dcim.cable", "ipam.ipaddress"},
+			})
+		}(c)
+	}
+	for i, p := range sd.cfg.Power.PDUs {
+		func(p pdu) {
+			q = append(q, netboxQuery{
+				name: fmt.Sprintf("power/pdus/%d", i),
+				load: func(ch chan<- []*targetgroup.Group) error { return sd.loadPDUs(p, ch) },
+				patch: func(id int64, ch chan<- []*targetgroup.Group) error {
+					patched := p
+					patched.DcimDevicesListParams.ID = idParam(id)
+					return sd.loadPDUs(patched, ch)
+				},
+				watch:   []string{"dcim.power"},
+				devices: true,
+			})
+		}(p)
+	}
+	for i, g := range sd.cfg.GraphQL {
+		func(g graphqlQuery) {
+			q = append(q, netboxQuery{
+				name: fmt.Sprintf("graphql/%d", i),
+				load: func(ch chan<- []*targetgroup.Group) error { return sd.loadGraphQL(g, ch) },
+				// The query can join any object
+				watch: []string{""},
+			})
+		}(g)
+	}
+	return
+}
 
-	for groups := range groupCh {
-		tgroups = append(tgroups, groups...)
+// loadData loads the groups of all queries, keyed by query name
+func (sd *NetboxDiscovery) loadData() (groups map[string][]*targetgroup.Group, err error) {
+	var eg errgroup.Group
+	var mu sync.Mutex
+	groups = make(map[string][]*targetgroup.Group)
+	sd.labels = newNetboxLabels(sd.netbox)
+	for _, q := range sd.queries() {
+		func(q netboxQuery) {
+			eg.Go(func() error {
+				tgroups, err := collectGroups(q.load)
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				groups[q.name] = tgroups
+				mu.Unlock()
+				return nil
+			})
+		}(q)
 	}
+	err = eg.Wait()
 	return
 }
 
+// collectGroups runs the load function of a query and returns the groups it sent
+func collectGroups(load func(groupsCh chan<- []*targetgroup.Group) error) ([]*targetgroup.Group, error) {
+	groupsCh := make(chan []*targetgroup.Group, 1)
+	if err := load(groupsCh); err != nil {
+		return nil, err
+	}
+	select {
+	case tgroups := <-groupsCh:
+		return tgroups, nil
+	default:
+		return nil, nil
+	}
+}
+
 func (sd *NetboxDiscovery) loadDcimDevices(d dcimDevice, groupsCh chan<- []*targetgroup.Group) (err error) {
 	var dcims []models.DeviceWithConfigContext
-	var wg sync.WaitGroup
-	var tgroups []*targetgroup.Group
-	groupCh := make(chan *targetgroup.Group, 0)
-	dcims, err = sd.netbox.DevicesByParams(d.DcimDevicesListParams)
+	dcims, err = sd.netbox.DevicesByParams(d.DcimDevicesListParams, d.RawQuery)
 	if err != nil {
 		dout, _ := yaml.Marshal(d.DcimDevicesListParams)
 		return fmt.Errorf("Error loading devices / Query=%s: %w", string(dout), err)
 	}
 	level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", fmt.Sprintf("found %d dcimDevices", len(dcims)))
+	tgroups, err := sd.createDeviceGroups(d.customParams, dcims)
+	if err != nil {
+		return
+	}
+	groupsCh <- tgroups
+	return
+}
+
+// createDeviceGroups creates the groups of all devices, resolving their ips at once where possible
+func (sd *NetboxDiscovery) createDeviceGroups(p customParams, dcims []models.DeviceWithConfigContext) (tgroups []*targetgroup.Group, err error) {
+	var wg sync.WaitGroup
+	groupCh := make(chan *targetgroup.Group, 0)
+	resolved, err := sd.resolveDeviceIPs(p.Target, dcims)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading device ips: %w", err)
+	}
 	wg.Add(len(dcims))
 	for _, dv := range dcims {
-		if sd.cfg.RateLimiter > 0 {
+		if sd.cfg.RateLimiter > 0 && resolved == nil {
 			<-sd.rateLimiter.C
 		}
 
-		go sd.createGroups(d.CustomLabels, d.MetricsLabel, d.Target, dv, &wg, groupCh)
+		go sd.createGroups(p, dv, resolved, &wg, groupCh)
 	}
 	go func() {
 		wg.Wait()
@@ -213,7 +435,6 @@ func (sd *NetboxDiscovery) loadDcimDevices(d dcimDevice, groupsCh chan<- []*targ
 	for group := range groupCh {
 		tgroups = append(tgroups, group)
 	}
-	groupsCh <- tgroups
 	return
 }
 
@@ -221,7 +442,7 @@ func (sd *NetboxDiscovery) loadVirtualizationVMs(d virtualizationVM, groupsCh ch
 	var wg sync.WaitGroup
 	var tgroups []*targetgroup.Group
 	groupCh := make(chan *targetgroup.Group, 0)
-	vms, err := sd.netbox.VMsByParams(d.VirtualizationVirtualMachinesListParams)
+	vms, err := sd.netbox.VMsByParams(d.VirtualizationVirtualMachinesListParams, d.RawQuery)
 	if err != nil {
 		dout, _ := yaml.Marshal(d)
 		return fmt.Errorf("Error loading vms %s: %w", string(dout), err)
@@ -232,7 +453,7 @@ func (sd *NetboxDiscovery) loadVirtualizationVMs(d virtualizationVM, groupsCh ch
 		if sd.cfg.RateLimiter > 0 {
 			<-sd.rateLimiter.C
 		}
-		go sd.createGroups(d.CustomLabels, d.MetricsLabel, d.Target, vm, &wg, groupCh)
+		go sd.createGroups(d.customParams, vm, nil, &wg, groupCh)
 	}
 	go func() {
 		wg.Wait()
@@ -246,16 +467,37 @@ func (sd *NetboxDiscovery) loadVirtualizationVMs(d virtualizationVM, groupsCh ch
 	return
 }
 
-func (sd *NetboxDiscovery) createGroups(c map[string]string, metricsLabel string, t int, d interface{}, wg *sync.WaitGroup, groupsCh chan<- *targetgroup.Group) {
-	cLabels := model.LabelSet{}
-	var tgroup *targetgroup.Group
-	defer wg.Done()
-	for k, v := range c {
-		cLabels[model.LabelName(k)] = model.LabelValue(v)
+// resolveDeviceIPs fetches the ips of all devices at once for the management and loopback targets.
+// It returns nil for the primary ip target, which needs no extra requests.
+func (sd *NetboxDiscovery) resolveDeviceIPs(t int, dcims []models.DeviceWithConfigContext) (map[int64][]netbox.DeviceIP, error) {
+	ids := make([]int64, 0, len(dcims))
+	for _, dv := range dcims {
+		ids = append(ids, dv.ID)
+	}
+	switch t {
+	case managementIP:
+		return sd.netbox.BulkManagementIPs(ids)
+	case loopback10:
+		return sd.netbox.BulkInterfaceNameIPs("Loopback10", ids)
 	}
+	return nil, nil
+}
+
+// createGroups creates the groups of a device or vm. The device ips are taken from resolved if it is not nil,
+// otherwise they are looked up per device.
+func (sd *NetboxDiscovery) createGroups(p customParams, d interface{}, resolved map[int64][]netbox.DeviceIP, wg *sync.WaitGroup, groupsCh chan<- *targetgroup.Group) {
+	cLabels := customLabels(p.CustomLabels)
+	var labels, extraLabels model.LabelSet
+	var deviceIPs []netbox.DeviceIP
+	var err error
+	defer wg.Done()
 	switch dv := d.(type) {
 	case models.DeviceWithConfigContext:
-		deviceIPs, err := sd.getDeviceIP(t, dv.ID, dv.PrimaryIP)
+		if resolved != nil {
+			deviceIPs = resolved[dv.ID]
+		} else {
+			deviceIPs, err = sd.getDeviceIP(p.Target, dv.ID, dv.PrimaryIP)
+		}
 		id := strconv.Itoa(int(dv.ID))
 		if err != nil {
 			level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Ignoring device: %s. Error: %s", id, err.Error()))
@@ -265,40 +507,37 @@ func (sd *NetboxDiscovery) createGroups(c map[string]string, metricsLabel string
 			level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Ignoring device: %s. Error: no device ips", id))
 			return
 		}
-		for _, deviceIP := range deviceIPs {
-			tgroup = &targetgroup.Group{
-				Source:  strconv.Itoa(rand.Intn(300000000)),
-				Labels:  make(model.LabelSet),
-				Targets: make([]model.LabelSet, 0, 1),
-			}
-			target := model.LabelSet{model.AddressLabel: model.LabelValue(deviceIP)}
-			labels := model.LabelSet{
-				model.LabelName("name"):          model.LabelValue(dv.DisplayName),
-				model.LabelName("server_name"):   model.LabelValue(*dv.Name),
-				model.LabelName("manufacturer"):  model.LabelValue(*dv.DeviceType.Manufacturer.Name),
-				model.LabelName("status"):        model.LabelValue(*dv.Status.Label),
-				model.LabelName("serial"):        model.LabelValue(dv.Serial),
-				model.LabelName("model"):         model.LabelValue(*dv.DeviceType.Model),
-				model.LabelName("server_id"):     model.LabelValue(id),
-				model.LabelName("role"):          model.LabelValue(*dv.DeviceRole.Slug),
-				model.LabelName("metrics_label"): model.LabelValue(metricsLabel),
-			}
-			if dv.Site != nil && dv.Site.Slug != nil {
-				labels[model.LabelName("site")] = model.LabelValue(*dv.Site.Slug)
-			}
-			if dv.Cluster != nil && dv.Cluster.Name != nil {
-				labels[model.LabelName("cluster")] = model.LabelValue(*dv.Cluster.Name)
-			}
-
-			labels = labels.Merge(cLabels)
-
-			tgroup.Labels = labels
-			tgroup.Targets = append(tgroup.Targets, target)
+		var manufacturer, deviceModel string
+		manufacturer, deviceModel, err = sd.labels.deviceModel(dv.DeviceType)
+		if err != nil {
+			level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Ignoring device: %s. Error loading device type: %s", id, err.Error()))
+			return
+		}
+		labels = model.LabelSet{
+			model.LabelName("name"):          model.LabelValue(dv.DisplayName),
+			model.LabelName("server_name"):   model.LabelValue(*dv.Name),
+			model.LabelName("manufacturer"):  model.LabelValue(manufacturer),
+			model.LabelName("status"):        model.LabelValue(*dv.Status.Label),
+			model.LabelName("serial"):        model.LabelValue(dv.Serial),
+			model.LabelName("model"):         model.LabelValue(deviceModel),
+			model.LabelName("server_id"):     model.LabelValue(id),
+			model.LabelName("role"):          model.LabelValue(*dv.DeviceRole.Slug),
+			model.LabelName("metrics_label"): model.LabelValue(p.MetricsLabel),
+		}
+		if dv.Site != nil && dv.Site.Slug != nil {
+			labels[model.LabelName("site")] = model.LabelValue(*dv.Site.Slug)
 		}
+		if dv.Cluster != nil && dv.Cluster.Name != nil {
+			labels[model.LabelName("cluster")] = model.LabelValue(*dv.Cluster.Name)
+		}
+		if dv.VirtualChassis != nil && dv.VirtualChassis.Master != nil {
+			labels[vcMasterLabel] = model.LabelValue(strconv.FormatBool(dv.VirtualChassis.Master.ID == dv.ID))
+		}
+		extraLabels, err = sd.labels.deviceLabels(dv, p.ExtraLabels)
 
 	case models.VirtualMachineWithConfigContext:
 		id := strconv.Itoa(int(dv.ID))
-		deviceIPs, err := sd.getDeviceIP(t, dv.ID, dv.PrimaryIP)
+		deviceIPs, err = sd.getDeviceIP(p.Target, dv.ID, dv.PrimaryIP)
 		if err != nil {
 			level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Ignoring vm: %s. Error: %s", id, err.Error()))
 			return
@@ -307,43 +546,68 @@ func (sd *NetboxDiscovery) createGroups(c map[string]string, metricsLabel string
 			level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Ignoring device: %s. Error: no vm ips", id))
 			return
 		}
-		for _, deviceIP := range deviceIPs {
-			tgroup = &targetgroup.Group{
-				Source:  strconv.Itoa(rand.Intn(300000000)),
-				Labels:  make(model.LabelSet),
-				Targets: make([]model.LabelSet, 0, 1),
-			}
-			target := model.LabelSet{model.AddressLabel: model.LabelValue(deviceIP)}
-			labels := model.LabelSet{
-				model.LabelName("state"):         model.LabelValue(*dv.Status.Label),
-				model.LabelName("server_name"):   model.LabelValue(*dv.Name),
-				model.LabelName("server_id"):     model.LabelValue(id),
-				model.LabelName("role"):          model.LabelValue(*dv.Role.Slug),
-				model.LabelName("metrics_label"): model.LabelValue(metricsLabel),
-			}
-			labels = labels.Merge(cLabels)
-			tgroup.Labels = labels
-			tgroup.Targets = append(tgroup.Targets, target)
+		labels = model.LabelSet{
+			model.LabelName("state"):         model.LabelValue(*dv.Status.Label),
+			model.LabelName("server_name"):   model.LabelValue(*dv.Name),
+			model.LabelName("server_id"):     model.LabelValue(id),
+			model.LabelName("role"):          model.LabelValue(*dv.Role.Slug),
+			model.LabelName("metrics_label"): model.LabelValue(p.MetricsLabel),
 		}
+		if dv.Site != nil && dv.Site.Slug != nil {
+			labels[model.LabelName("site")] = model.LabelValue(*dv.Site.Slug)
+		}
+		if dv.Cluster != nil && dv.Cluster.Name != nil {
+			labels[model.LabelName("cluster")] = model.LabelValue(*dv.Cluster.Name)
+		}
+		extraLabels, err = sd.labels.vmLabels(dv, p.ExtraLabels)
 
 	default:
 		level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("not supported device interface"))
 		return
 	}
-	groupsCh <- tgroup
+	if err != nil {
+		level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Missing extra labels for %s. Error: %s", labels[model.LabelName("server_name")], err.Error()))
+	}
+	labels = labels.Merge(extraLabels)
+
+	if len(deviceIPs) > 1 {
+		level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", fmt.Sprintf("found %d ips for %s", len(deviceIPs), labels[model.LabelName("server_name")]))
+	}
+	// Every address becomes its own group, so that each target keeps its interface and family labels.
+	for _, deviceIP := range deviceIPs {
+		tgroup := &targetgroup.Group{
+			Source:  strconv.Itoa(rand.Intn(300000000)),
+			Labels:  make(model.LabelSet),
+			Targets: make([]model.LabelSet, 0, 1),
+		}
+		target := model.LabelSet{model.AddressLabel: model.LabelValue(deviceIP.Address)}
+		ipLabels := labels.Clone()
+		ipLabels[model.LabelName("address_family")] = model.LabelValue(deviceIP.Family)
+		if deviceIP.Interface != "" {
+			ipLabels[model.LabelName("interface")] = model.LabelValue(deviceIP.Interface)
+		}
+		tgroup.Labels = ipLabels.Merge(cLabels)
+		tgroup.Targets = append(tgroup.Targets, target)
+		groupsCh <- tgroup
+	}
 }
 
-func (sd *NetboxDiscovery) getDeviceIP(t int, id int64, i *models.NestedIPAddress) (ips []string, err error) {
-	ips = make([]string, 0)
+func (sd *NetboxDiscovery) getDeviceIP(t int, id int64, i *models.NestedIPAddress) (ips []netbox.DeviceIP, err error) {
+	ips = make([]netbox.DeviceIP, 0)
 	switch t {
 	case managementIP:
-		ips, err = sd.netbox.ManagementIPs(strconv.FormatInt(id, 10))
+		ips, err = sd.netbox.ManagementInterfaceIPs(strconv.FormatInt(id, 10))
 	case primaryIP:
 		var ip string
 		ip, err = sd.netbox.GetNestedDeviceIP(i)
-		ips = append(ips, ip)
+		if err != nil {
+			break
+		}
+		var deviceIP netbox.DeviceIP
+		deviceIP, err = netbox.NewDeviceIP(ip, "")
+		ips = append(ips, deviceIP)
 	case loopback10:
-		ips, err = sd.netbox.DeviceInterfaceNameIPs("Loopback10", strconv.FormatInt(id, 10))
+		ips, err = sd.netbox.InterfaceNameIPs("Loopback10", strconv.FormatInt(id, 10))
 	default:
 		return ips, fmt.Errorf("Error getting ip from device: %d. Error: %s", id, "unknown target in config")
 	}
@@ -355,18 +619,49 @@ func (sd *NetboxDiscovery) getDeviceIP(t int, id int64, i *models.NestedIPAddres
 
 func (sd *NetboxDiscovery) setMetrics() {
 	labels := make(map[string]int, 0)
-	for _, dcim := range sd.cfg.DCIM.Devices {
-		if _, ok := labels[dcim.MetricsLabel]; !ok {
-			labels[dcim.MetricsLabel] = 0
-			setMetricsLabelAndValue(sd.status.Targets, dcim.MetricsLabel, sd.adapter.GetNumberOfTargetsFor(dcim.MetricsLabel))
+	for _, metricsLabel := range sd.metricsLabels() {
+		if _, ok := labels[metricsLabel]; !ok {
+			labels[metricsLabel] = 0
+			setMetricsLabelAndValue(sd.status.Targets, metricsLabel, sd.adapter.GetNumberOfTargetsFor(metricsLabel))
 		}
 	}
+}
+
+func (sd *NetboxDiscovery) metricsLabels() (l []string) {
+	for _, dcim := range sd.cfg.DCIM.Devices {
+		l = append(l, dcim.MetricsLabel)
+	}
+	for _, intf := range sd.cfg.DCIM.Interfaces {
+		l = append(l, intf.MetricsLabel)
+	}
+	for _, r := range sd.cfg.DCIM.Racks {
+		l = append(l, r.MetricsLabel)
+	}
 	for _, vm := range sd.cfg.Virtualization.VMs {
-		if _, ok := labels[vm.MetricsLabel]; !ok {
-			labels[vm.MetricsLabel] = 0
-			setMetricsLabelAndValue(sd.status.Targets, vm.MetricsLabel, sd.adapter.GetNumberOfTargetsFor(vm.MetricsLabel))
-		}
+		l = append(l, vm.MetricsLabel)
+	}
+	for _, ip := range sd.cfg.IPAM.IPAddresses {
+		l = append(l, ip.MetricsLabel)
 	}
+	for _, prefix := range sd.cfg.IPAM.Prefixes {
+		l = append(l, prefix.MetricsLabel)
+	}
+	for _, service := range sd.cfg.IPAM.Services {
+		l = append(l, service.MetricsLabel)
+	}
+	for _, c := range sd.cfg.Circuits.Circuits {
+		l = append(l, c.MetricsLabel)
+	}
+	for _, p := range sd.cfg.Power.PDUs {
+		l = append(l, p.MetricsLabel)
+	}
+	for _, g := range sd.cfg.GraphQL {
+		l = append(l, g.MetricsLabel)
+	}
+	for _, nd := range sd.instances {
+		l = append(l, nd.metricsLabels()...)
+	}
+	return
 }
 
 func (sd *NetboxDiscovery) Up() bool {
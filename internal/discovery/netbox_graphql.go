@@ -0,0 +1,74 @@
+package discovery
+
+import (
+	"fmt"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/sapcc/atlas/pkg/netbox"
+)
+
+func (sd *NetboxDiscovery) loadGraphQL(q graphqlQuery, groupsCh chan<- []*targetgroup.Group) (err error) {
+	var tgroups []*targetgroup.Group
+	data, err := sd.netbox.GraphQL(q.Query, q.Variables)
+	if err != nil {
+		return fmt.Errorf("Error loading graphql query %s: %w", q.Results, err)
+	}
+	objects := netbox.FieldValues(data, q.Results)
+	level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", fmt.Sprintf("found %d graphql %s", len(objects), q.Results))
+	for i, o := range objects {
+		tgroups = append(tgroups, sd.createGraphQLGroups(q, o, i)...)
+	}
+	groupsCh <- tgroups
+	return
+}
+
+// createGraphQLGroups creates one group per target address of the object.
+// Label paths yielding several values are joined with commas. The groups are identified by the id of the object,
+// or else its index in the results, as the same address can belong to several objects (e.g. in different vrfs).
+func (sd *NetboxDiscovery) createGraphQLGroups(q graphqlQuery, o interface{}, index int) (tgroups []*targetgroup.Group) {
+	labels := model.LabelSet{
+		model.LabelName("metrics_label"): model.LabelValue(q.MetricsLabel),
+	}
+	values := make(map[string]string, len(q.Labels))
+	for name, path := range q.Labels {
+		var strs []string
+		for _, v := range netbox.FieldValues(o, path) {
+			strs = append(strs, netbox.FieldString(v))
+		}
+		setJoinedLabel(values, name, strs)
+	}
+	labels = labels.Merge(customLabels(values)).Merge(customLabels(q.CustomLabels))
+
+	addresses := netbox.FieldValues(o, q.Target)
+	if len(addresses) == 0 {
+		level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", fmt.Sprintf("no target %s for graphql object %s", q.Target, labels))
+		return
+	}
+	id := fmt.Sprintf("index:%d", index)
+	if ids := netbox.FieldValues(o, "id"); len(ids) == 1 {
+		id = netbox.FieldString(ids[0])
+	}
+	seen := make(map[string]bool, len(addresses))
+	for _, a := range addresses {
+		ip, err := netbox.NewDeviceIP(netbox.FieldString(a), "")
+		if err != nil {
+			level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Ignoring graphql target. Error: %s", err.Error()))
+			continue
+		}
+		if seen[ip.Address] {
+			continue
+		}
+		seen[ip.Address] = true
+		ipLabels := labels.Clone()
+		ipLabels[model.LabelName("address_family")] = model.LabelValue(ip.Family)
+		tgroups = append(tgroups, &targetgroup.Group{
+			Source:  fmt.Sprintf("graphql/%s/%s/%s", q.Results, id, ip.Address),
+			Labels:  ipLabels,
+			Targets: []model.LabelSet{{model.AddressLabel: model.LabelValue(ip.Address)}},
+		})
+	}
+	return
+}
@@ -0,0 +1,104 @@
+package discovery
+
+import (
+	"fmt"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/sapcc/atlas/pkg/netbox"
+)
+
+// newNetboxInstances creates a discovery per configured instance. They share the refresh, resync and rate limit
+// settings of the main netbox, and are loaded and sent together with it.
+func newNetboxInstances(cfg netboxConfig, region string, l log.Logger) (instances []*NetboxDiscovery, err error) {
+	names := map[string]bool{cfg.Instance: true}
+	for _, i := range cfg.Instances {
+		if i.Name == "" {
+			i.Name = i.NetboxHost
+		}
+		if names[i.Name] {
+			return nil, fmt.Errorf("duplicate netbox instance %s", i.Name)
+		}
+		names[i.Name] = true
+		nClient, err := netbox.NewWithConfig(i.NetboxHost, i.NetboxAPIToken, i.NetboxClient)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating netbox instance %s: %w", i.Name, err)
+		}
+		nClient.SetCacheTTL(i.CacheTTL)
+		if i.WebhookSecret == "" {
+			i.WebhookSecret = cfg.WebhookSecret
+		}
+		instances = append(instances, &NetboxDiscovery{
+			netbox:          nClient,
+			region:          region,
+			refreshInterval: cfg.RefreshInterval,
+			logger:          log.With(l, "netbox_instance", i.Name),
+			cfg: netboxConfig{
+				RefreshInterval:    cfg.RefreshInterval,
+				FullResyncInterval: cfg.FullResyncInterval,
+				NetboxHost:         i.NetboxHost,
+				NetboxAPIToken:     i.NetboxAPIToken,
+				NetboxClient:       i.NetboxClient,
+				RateLimiter:        cfg.RateLimiter,
+				DCIM:               i.DCIM,
+				Virtualization:     i.Virtualization,
+				CacheTTL:           i.CacheTTL,
+				WebhookSecret:      i.WebhookSecret,
+				WriteBack:          cfg.WriteBack,
+			},
+			instance: i.Name,
+		})
+	}
+	return
+}
+
+// netboxes returns the main netbox (if netbox_host is set) and all additional instances
+func (sd *NetboxDiscovery) netboxes() (nds []*NetboxDiscovery) {
+	if sd.netbox != nil {
+		nds = append(nds, sd)
+	}
+	return append(nds, sd.instances...)
+}
+
+// queryGroups are the groups of a query of the netbox nd
+type queryGroups struct {
+	nd     *NetboxDiscovery
+	query  netboxQuery
+	groups []*targetgroup.Group
+}
+
+// instanceGroups returns the groups of the netbox per query, in configured order.
+// With several instances configured, they are labelled with the instance name.
+func (sd *NetboxDiscovery) instanceGroups() (queries []queryGroups) {
+	for _, q := range sd.queries() {
+		groups, ok := sd.groups[q.name]
+		if !ok {
+			continue
+		}
+		if sd.instance == "" {
+			queries = append(queries, queryGroups{nd: sd, query: q, groups: groups})
+			continue
+		}
+		labelled := make([]*targetgroup.Group, 0, len(groups))
+		for _, group := range groups {
+			labels := group.Labels.Clone()
+			labels[model.LabelName("netbox_instance")] = model.LabelValue(sd.instance)
+			labelled = append(labelled, &targetgroup.Group{
+				Source:  sd.instance + "/" + group.Source,
+				Labels:  labels,
+				Targets: group.Targets,
+			})
+		}
+		queries = append(queries, queryGroups{nd: sd, query: q, groups: labelled})
+	}
+	return
+}
+
+// allQueryGroups returns the groups of all netboxes per query, before deduplication
+func (sd *NetboxDiscovery) allQueryGroups() (queries []queryGroups) {
+	for _, nd := range sd.netboxes() {
+		queries = append(queries, nd.instanceGroups()...)
+	}
+	return
+}
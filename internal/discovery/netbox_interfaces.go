@@ -0,0 +1,145 @@
+package discovery
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/netbox-community/go-netbox/netbox/models"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/sapcc/atlas/pkg/netbox"
+	"gopkg.in/yaml.v2"
+)
+
+func (sd *NetboxDiscovery) loadDcimInterfaces(d dcimInterface, groupsCh chan<- []*targetgroup.Group) (err error) {
+	var wg sync.WaitGroup
+	var tgroups []*targetgroup.Group
+	groupCh := make(chan *targetgroup.Group, 0)
+	dcims, err := sd.netbox.DevicesByParams(d.Devices, d.DevicesRawQuery)
+	if err != nil {
+		dout, _ := yaml.Marshal(d.Devices)
+		return fmt.Errorf("Error loading interface devices / Query=%s: %w", string(dout), err)
+	}
+	level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", fmt.Sprintf("found %d dcimDevices for interfaces", len(dcims)))
+	resolved, err := sd.resolveDeviceIPs(d.Target, dcims)
+	if err != nil {
+		return fmt.Errorf("Error loading interface device ips: %w", err)
+	}
+	ids := make([]int64, 0, len(dcims))
+	for _, dv := range dcims {
+		ids = append(ids, dv.ID)
+	}
+	intfs, err := sd.netbox.BulkInterfacesByParams(d.DcimInterfacesListParams, d.RawQuery, ids)
+	if err != nil {
+		dout, _ := yaml.Marshal(d.DcimInterfacesListParams)
+		return fmt.Errorf("Error loading interfaces / Query=%s: %w", string(dout), err)
+	}
+	wg.Add(len(dcims))
+	for _, dv := range dcims {
+		if sd.cfg.RateLimiter > 0 {
+			<-sd.rateLimiter.C
+		}
+		go sd.createInterfaceGroups(d, dv, intfs[dv.ID], resolved, &wg, groupCh)
+	}
+	go func() {
+		wg.Wait()
+		close(groupCh)
+	}()
+
+	for group := range groupCh {
+		tgroups = append(tgroups, group)
+	}
+	groupsCh <- tgroups
+	return
+}
+
+// createInterfaceGroups creates one group per selected interface of the device.
+// The target is the device address, the interface is passed on as ifName parameter.
+func (sd *NetboxDiscovery) createInterfaceGroups(d dcimInterface, dv models.DeviceWithConfigContext, intfs []netbox.Interface, resolved map[int64][]netbox.DeviceIP, wg *sync.WaitGroup, groupsCh chan<- *targetgroup.Group) {
+	defer wg.Done()
+	id := strconv.FormatInt(dv.ID, 10)
+	deviceIPs := resolved[dv.ID]
+	var err error
+	if resolved == nil {
+		deviceIPs, err = sd.getDeviceIP(d.Target, dv.ID, dv.PrimaryIP)
+	}
+	if err != nil {
+		level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Ignoring device: %s. Error: %s", id, err.Error()))
+		return
+	}
+	if len(deviceIPs) == 0 {
+		level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Ignoring device: %s. Error: no device ips", id))
+		return
+	}
+	if len(deviceIPs) > 1 {
+		level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", fmt.Sprintf("device %s has %d ips, using %s for its interfaces", id, len(deviceIPs), deviceIPs[0].Address))
+	}
+
+	labels := model.LabelSet{
+		model.LabelName("server_name"):   model.LabelValue(dv.DisplayName),
+		model.LabelName("server_id"):     model.LabelValue(id),
+		model.LabelName("metrics_label"): model.LabelValue(d.MetricsLabel),
+	}
+	if dv.DeviceRole != nil && dv.DeviceRole.Slug != nil {
+		labels[model.LabelName("role")] = model.LabelValue(*dv.DeviceRole.Slug)
+	}
+	if dv.Site != nil && dv.Site.Slug != nil {
+		labels[model.LabelName("site")] = model.LabelValue(*dv.Site.Slug)
+	}
+	extraLabels, err := sd.labels.deviceLabels(dv, d.ExtraLabels)
+	if err != nil {
+		level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Missing extra labels for %s. Error: %s", dv.DisplayName, err.Error()))
+	}
+	labels = labels.Merge(extraLabels)
+
+	for _, intf := range intfs {
+		if intf.Name == nil {
+			continue
+		}
+		intfLabels := interfaceLabels(intf)
+		intfLabels[model.LabelName("__param_ifName")] = model.LabelValue(*intf.Name)
+		if ifIndex, ok := interfaceCustomField(intf, d.IfIndexField); ok {
+			intfLabels[model.LabelName("__param_ifIndex")] = model.LabelValue(ifIndex)
+		}
+		groupsCh <- &targetgroup.Group{
+			Source:  fmt.Sprintf("interface/%d", intf.ID),
+			Labels:  labels.Merge(intfLabels).Merge(customLabels(d.CustomLabels)),
+			Targets: []model.LabelSet{{model.AddressLabel: model.LabelValue(deviceIPs[0].Address)}},
+		}
+	}
+}
+
+func interfaceLabels(intf netbox.Interface) model.LabelSet {
+	labels := model.LabelSet{
+		model.LabelName("interface"):    model.LabelValue(*intf.Name),
+		model.LabelName("interface_id"): model.LabelValue(strconv.FormatInt(intf.ID, 10)),
+	}
+	if intf.Type != nil && intf.Type.Value != nil {
+		labels[model.LabelName("interface_type")] = model.LabelValue(*intf.Type.Value)
+	}
+	if intf.Description != "" {
+		labels[model.LabelName("interface_description")] = model.LabelValue(intf.Description)
+	}
+	if peerDevice, peerInterface, ok := netbox.InterfacePeer(intf.Interface); ok {
+		labels[model.LabelName("peer_device")] = model.LabelValue(peerDevice)
+		labels[model.LabelName("peer_interface")] = model.LabelValue(peerInterface)
+	}
+	return labels
+}
+
+// interfaceCustomField returns the value of the custom field of the interface, if it is set
+func interfaceCustomField(intf netbox.Interface, field string) (string, bool) {
+	if field == "" {
+		return "", false
+	}
+	switch v := intf.CustomFields[field].(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case string:
+		return v, v != ""
+	}
+	return "", false
+}
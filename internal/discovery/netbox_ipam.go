@@ -0,0 +1,299 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	nipam "github.com/netbox-community/go-netbox/netbox/client/ipam"
+	"github.com/netbox-community/go-netbox/netbox/models"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/sapcc/atlas/pkg/netbox"
+	"gopkg.in/yaml.v2"
+)
+
+const defaultMaxHosts = 256
+
+func (sd *NetboxDiscovery) loadIPAddresses(q ipamIPAddress, groupsCh chan<- []*targetgroup.Group) (err error) {
+	ips, err := sd.netbox.IPAddressesByParams(q.IpamIPAddressesListParams, q.RawQuery)
+	if err != nil {
+		dout, _ := yaml.Marshal(q.IpamIPAddressesListParams)
+		return fmt.Errorf("Error loading ip addresses / Query=%s: %w", string(dout), err)
+	}
+	level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", fmt.Sprintf("found %d ipamIPAddresses", len(ips)))
+	groupsCh <- sd.createIPAddressGroups(q.entryParams, ips, "")
+	return
+}
+
+func (sd *NetboxDiscovery) loadPrefixes(q ipamPrefix, groupsCh chan<- []*targetgroup.Group) (err error) {
+	var tgroups []*targetgroup.Group
+	prefixes, err := sd.netbox.PrefixesByParams(q.IpamPrefixesListParams, q.RawQuery)
+	if err != nil {
+		dout, _ := yaml.Marshal(q.IpamPrefixesListParams)
+		return fmt.Errorf("Error loading prefixes / Query=%s: %w", string(dout), err)
+	}
+	level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", fmt.Sprintf("found %d ipamPrefixes", len(prefixes)))
+	for _, prefix := range prefixes {
+		if prefix.Prefix == nil {
+			continue
+		}
+		if q.ExpandHosts {
+			tgroup, err := sd.createPrefixGroup(q, prefix)
+			if err != nil {
+				level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Ignoring prefix: %s. Error: %s", *prefix.Prefix, err.Error()))
+				continue
+			}
+			tgroups = append(tgroups, tgroup)
+			continue
+		}
+
+		if sd.cfg.RateLimiter > 0 {
+			<-sd.rateLimiter.C
+		}
+		params := nipam.IpamIPAddressesListParams{Parent: prefix.Prefix}
+		if prefix.Vrf != nil {
+			vrfID := strconv.FormatInt(prefix.Vrf.ID, 10)
+			params.VrfID = &vrfID
+		}
+		ips, err := sd.netbox.IPAddressesByParams(params, nil)
+		if err != nil {
+			return fmt.Errorf("Error loading ip addresses of prefix %s: %w", *prefix.Prefix, err)
+		}
+		tgroups = append(tgroups, sd.createIPAddressGroups(q.entryParams, ips, *prefix.Prefix)...)
+	}
+	groupsCh <- tgroups
+	return
+}
+
+// createIPAddressGroups creates a group per ip address. If parent is empty,
+// the prefix label is derived from the address itself.
+func (sd *NetboxDiscovery) createIPAddressGroups(p entryParams, ips []models.IPAddress, parent string) (tgroups []*targetgroup.Group) {
+	for _, ip := range ips {
+		if ip.Address == nil {
+			continue
+		}
+		deviceIP, err := netbox.NewDeviceIP(*ip.Address, "")
+		if err != nil {
+			level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Ignoring ip address: %d. Error: %s", ip.ID, err.Error()))
+			continue
+		}
+		prefix := parent
+		if prefix == "" {
+			if _, ipnet, err := net.ParseCIDR(*ip.Address); err == nil {
+				prefix = ipnet.String()
+			}
+		}
+		labels := model.LabelSet{
+			model.LabelName("ip_address_id"):  model.LabelValue(strconv.FormatInt(ip.ID, 10)),
+			model.LabelName("address_family"): model.LabelValue(deviceIP.Family),
+			model.LabelName("prefix"):         model.LabelValue(prefix),
+			model.LabelName("dns_name"):       model.LabelValue(ip.DNSName),
+			model.LabelName("metrics_label"):  model.LabelValue(p.MetricsLabel),
+		}
+		if ip.Vrf != nil && ip.Vrf.Name != nil {
+			labels[model.LabelName("vrf")] = model.LabelValue(*ip.Vrf.Name)
+		}
+		if ip.Role != nil && ip.Role.Value != nil {
+			labels[model.LabelName("role")] = model.LabelValue(*ip.Role.Value)
+		}
+		if ip.Status != nil && ip.Status.Value != nil {
+			labels[model.LabelName("status")] = model.LabelValue(*ip.Status.Value)
+		}
+		if ip.Tenant != nil && ip.Tenant.Slug != nil {
+			labels[model.LabelName("tenant")] = model.LabelValue(*ip.Tenant.Slug)
+		}
+		// Addresses overlap across vrfs, so the group is identified by the id of the address
+		tgroup := &targetgroup.Group{
+			Source:  fmt.Sprintf("ip_address/%d", ip.ID),
+			Labels:  labels.Merge(customLabels(p.CustomLabels)),
+			Targets: []model.LabelSet{{model.AddressLabel: model.LabelValue(deviceIP.Address)}},
+		}
+		tgroups = append(tgroups, tgroup)
+	}
+	return
+}
+
+// createPrefixGroup creates one group with every host address of the prefix as target
+func (sd *NetboxDiscovery) createPrefixGroup(q ipamPrefix, prefix models.Prefix) (tgroup *targetgroup.Group, err error) {
+	maxHosts := q.MaxHosts
+	if maxHosts <= 0 {
+		maxHosts = defaultMaxHosts
+	}
+	hosts, err := expandPrefix(*prefix.Prefix, maxHosts)
+	if err != nil {
+		return
+	}
+	family := "ipv6"
+	if prefix.Family != nil && prefix.Family.Value != nil && *prefix.Family.Value == 4 {
+		family = "ipv4"
+	}
+	labels := model.LabelSet{
+		model.LabelName("prefix_id"):      model.LabelValue(strconv.FormatInt(prefix.ID, 10)),
+		model.LabelName("prefix"):         model.LabelValue(*prefix.Prefix),
+		model.LabelName("address_family"): model.LabelValue(family),
+		model.LabelName("metrics_label"):  model.LabelValue(q.MetricsLabel),
+	}
+	if prefix.Vrf != nil && prefix.Vrf.Name != nil {
+		labels[model.LabelName("vrf")] = model.LabelValue(*prefix.Vrf.Name)
+	}
+	if prefix.Role != nil && prefix.Role.Slug != nil {
+		labels[model.LabelName("role")] = model.LabelValue(*prefix.Role.Slug)
+	}
+	if prefix.Status != nil && prefix.Status.Value != nil {
+		labels[model.LabelName("status")] = model.LabelValue(*prefix.Status.Value)
+	}
+	if prefix.Tenant != nil && prefix.Tenant.Slug != nil {
+		labels[model.LabelName("tenant")] = model.LabelValue(*prefix.Tenant.Slug)
+	}
+	if prefix.Site != nil && prefix.Site.Slug != nil {
+		labels[model.LabelName("site")] = model.LabelValue(*prefix.Site.Slug)
+	}
+	tgroup = &targetgroup.Group{
+		Source:  fmt.Sprintf("prefix/%d", prefix.ID),
+		Labels:  labels.Merge(customLabels(q.CustomLabels)),
+		Targets: make([]model.LabelSet, 0, len(hosts)),
+	}
+	for _, host := range hosts {
+		tgroup.Targets = append(tgroup.Targets, model.LabelSet{model.AddressLabel: model.LabelValue(host)})
+	}
+	return
+}
+
+func (sd *NetboxDiscovery) loadServices(q ipamService, groupsCh chan<- []*targetgroup.Group) (err error) {
+	var tgroups []*targetgroup.Group
+	services, err := sd.netbox.ServicesByParams(q.IpamServicesListParams, q.RawQuery)
+	if err != nil {
+		dout, _ := yaml.Marshal(q.IpamServicesListParams)
+		return fmt.Errorf("Error loading services / Query=%s: %w", string(dout), err)
+	}
+	level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", fmt.Sprintf("found %d ipamServices", len(services)))
+	for _, service := range services {
+		tgroup, err := sd.createServiceGroup(q.entryParams, service)
+		if err != nil {
+			level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Ignoring service: %d. Error: %s", service.ID, err.Error()))
+			continue
+		}
+		tgroups = append(tgroups, tgroup)
+	}
+	groupsCh <- tgroups
+	return
+}
+
+// createServiceGroup creates one group with an ip:port target for every ip and port of the service.
+// Services without ips fall back to the primary ip of their device or vm.
+func (sd *NetboxDiscovery) createServiceGroup(p entryParams, service netbox.Service) (tgroup *targetgroup.Group, err error) {
+	ports := service.Ports
+	// netbox versions before 2.10 only know a single port
+	if len(ports) == 0 && service.Port != nil {
+		ports = []int64{*service.Port}
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no port")
+	}
+	labels := model.LabelSet{
+		model.LabelName("service_id"):    model.LabelValue(strconv.FormatInt(service.ID, 10)),
+		model.LabelName("metrics_label"): model.LabelValue(p.MetricsLabel),
+	}
+	if service.Name != nil {
+		labels[model.LabelName("service")] = model.LabelValue(*service.Name)
+	}
+	if service.Protocol != nil && service.Protocol.Value != nil {
+		labels[model.LabelName("protocol")] = model.LabelValue(*service.Protocol.Value)
+	}
+
+	var ownerID int64
+	isVM := false
+	switch {
+	case service.Device != nil:
+		ownerID = service.Device.ID
+		if service.Device.Name != nil {
+			labels[model.LabelName("server_name")] = model.LabelValue(*service.Device.Name)
+		}
+	case service.VirtualMachine != nil:
+		ownerID = service.VirtualMachine.ID
+		isVM = true
+		if service.VirtualMachine.Name != nil {
+			labels[model.LabelName("server_name")] = model.LabelValue(*service.VirtualMachine.Name)
+		}
+	}
+	labels[model.LabelName("server_id")] = model.LabelValue(strconv.FormatInt(ownerID, 10))
+
+	addresses := make([]string, 0, len(service.Ipaddresses))
+	for _, ip := range service.Ipaddresses {
+		if ip == nil || ip.Address == nil {
+			continue
+		}
+		addresses = append(addresses, *ip.Address)
+	}
+	if len(addresses) == 0 {
+		if ownerID == 0 {
+			return nil, fmt.Errorf("no ip addresses")
+		}
+		if sd.cfg.RateLimiter > 0 {
+			<-sd.rateLimiter.C
+		}
+		primaryIP, err := sd.netbox.PrimaryIP(ownerID, isVM)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, primaryIP)
+	}
+
+	tgroup = &targetgroup.Group{
+		Source:  fmt.Sprintf("service/%d", service.ID),
+		Labels:  labels.Merge(customLabels(p.CustomLabels)),
+		Targets: make([]model.LabelSet, 0, len(addresses)*len(ports)),
+	}
+	for _, address := range addresses {
+		deviceIP, err := netbox.NewDeviceIP(address, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, port := range ports {
+			port := strconv.FormatInt(port, 10)
+			tgroup.Targets = append(tgroup.Targets, model.LabelSet{
+				model.AddressLabel:      model.LabelValue(net.JoinHostPort(deviceIP.Address, port)),
+				model.LabelName("port"): model.LabelValue(port),
+			})
+		}
+	}
+	return
+}
+
+// expandPrefix returns all host addresses of the prefix. For ipv4 prefixes
+// larger than /31 the network and broadcast addresses are left out.
+func expandPrefix(prefix string, maxHosts int) (hosts []string, err error) {
+	_, ipnet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return
+	}
+	ones, bits := ipnet.Mask.Size()
+	hostBits := bits - ones
+	if hostBits >= 31 || 1<<uint(hostBits) > maxHosts+2 {
+		return hosts, fmt.Errorf("prefix %s exceeds max_hosts %d", prefix, maxHosts)
+	}
+	ip := make(net.IP, len(ipnet.IP))
+	copy(ip, ipnet.IP)
+	for ; ipnet.Contains(ip); incIP(ip) {
+		hosts = append(hosts, ip.String())
+	}
+	if ipnet.IP.To4() != nil && hostBits > 1 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+	if len(hosts) > maxHosts {
+		return nil, fmt.Errorf("prefix %s exceeds max_hosts %d", prefix, maxHosts)
+	}
+	return
+}
+
+func incIP(ip net.IP) {
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			return
+		}
+	}
+}
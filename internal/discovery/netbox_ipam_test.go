@@ -0,0 +1,38 @@
+package discovery
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandPrefix(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		maxHosts int
+		want     []string
+		wantErr  bool
+	}{
+		{prefix: "10.0.0.0/30", maxHosts: 16, want: []string{"10.0.0.1", "10.0.0.2"}},
+		{prefix: "10.0.0.4/31", maxHosts: 16, want: []string{"10.0.0.4", "10.0.0.5"}},
+		{prefix: "10.0.0.7/32", maxHosts: 16, want: []string{"10.0.0.7"}},
+		{prefix: "10.0.0.5/30", maxHosts: 16, want: []string{"10.0.0.5", "10.0.0.6"}},
+		{prefix: "10.0.0.252/30", maxHosts: 2, want: []string{"10.0.0.253", "10.0.0.254"}},
+		{prefix: "10.0.0.255/31", maxHosts: 2, want: []string{"10.0.0.254", "10.0.0.255"}},
+		{prefix: "10.0.0.0/29", maxHosts: 5, wantErr: true},
+		{prefix: "10.0.0.0/8", maxHosts: 256, wantErr: true},
+		{prefix: "2001:db8::/126", maxHosts: 16, want: []string{"2001:db8::", "2001:db8::1", "2001:db8::2", "2001:db8::3"}},
+		{prefix: "2001:db8::/64", maxHosts: 256, wantErr: true},
+		{prefix: "10.0.0.1", maxHosts: 16, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			got, err := expandPrefix(tt.prefix, tt.maxHosts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandPrefix() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandPrefix() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
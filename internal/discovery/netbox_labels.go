@@ -0,0 +1,234 @@
+package discovery
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/netbox-community/go-netbox/netbox/models"
+	"github.com/prometheus/common/model"
+	"github.com/sapcc/atlas/pkg/netbox"
+)
+
+// netboxLabels resolves the nested netbox objects needed for the extra labels.
+// Lookups are memoized, so a new instance should be used for every refresh.
+type netboxLabels struct {
+	sync.Mutex
+	netbox   *netbox.Netbox
+	sites    map[int64]*models.Site
+	regions  map[int64]*models.Region
+	tenants  map[int64]*models.Tenant
+	clusters map[int64]*models.Cluster
+	feeds    map[int64]*models.PowerFeed
+}
+
+func newNetboxLabels(nb *netbox.Netbox) *netboxLabels {
+	return &netboxLabels{
+		netbox:   nb,
+		sites:    make(map[int64]*models.Site),
+		regions:  make(map[int64]*models.Region),
+		tenants:  make(map[int64]*models.Tenant),
+		clusters: make(map[int64]*models.Cluster),
+		feeds:    make(map[int64]*models.PowerFeed),
+	}
+}
+
+func (l *netboxLabels) deviceLabels(dv models.DeviceWithConfigContext, e extraLabels) (labels model.LabelSet, err error) {
+	labels = model.LabelSet{}
+	if e.Rack && dv.Rack != nil && dv.Rack.Name != nil {
+		labels[model.LabelName("rack")] = model.LabelValue(*dv.Rack.Name)
+	}
+	if e.RackPosition && dv.Position != nil {
+		labels[model.LabelName("rack_position")] = model.LabelValue(strconv.FormatInt(*dv.Position, 10))
+	}
+	if e.Face && dv.Face != nil && dv.Face.Value != nil {
+		labels[model.LabelName("face")] = model.LabelValue(*dv.Face.Value)
+	}
+	if e.AssetTag && dv.AssetTag != nil {
+		labels[model.LabelName("asset_tag")] = model.LabelValue(*dv.AssetTag)
+	}
+	if e.VirtualChassis && dv.VirtualChassis != nil && dv.VirtualChassis.Name != nil {
+		labels[model.LabelName("virtual_chassis")] = model.LabelValue(*dv.VirtualChassis.Name)
+	}
+	if e.Platform && dv.Platform != nil && dv.Platform.Slug != nil {
+		labels[model.LabelName("platform")] = model.LabelValue(*dv.Platform.Slug)
+	}
+	err = l.setSharedLabels(labels, e, dv.Site, dv.Tenant, dv.Cluster)
+	return
+}
+
+func (l *netboxLabels) vmLabels(vm models.VirtualMachineWithConfigContext, e extraLabels) (labels model.LabelSet, err error) {
+	labels = model.LabelSet{}
+	if e.Platform && vm.Platform != nil && vm.Platform.Slug != nil {
+		labels[model.LabelName("platform")] = model.LabelValue(*vm.Platform.Slug)
+	}
+	err = l.setSharedLabels(labels, e, vm.Site, vm.Tenant, vm.Cluster)
+	return
+}
+
+// setSharedLabels sets the labels devices and vms have in common.
+// It keeps going on lookup errors, so that as many labels as possible are set.
+func (l *netboxLabels) setSharedLabels(labels model.LabelSet, e extraLabels, s *models.NestedSite, t *models.NestedTenant, c *models.NestedCluster) (err error) {
+	if e.Region && s != nil {
+		var regions []*models.Region
+		if regions, err = l.regionHierarchy(s.ID); err == nil && len(regions) > 0 {
+			path := make([]string, 0, len(regions))
+			for i := len(regions) - 1; i >= 0; i-- {
+				path = append(path, *regions[i].Slug)
+			}
+			labels[model.LabelName("region")] = model.LabelValue(*regions[0].Slug)
+			labels[model.LabelName("region_path")] = model.LabelValue(strings.Join(path, "/"))
+			if len(regions) > 1 {
+				labels[model.LabelName("parent_region")] = model.LabelValue(*regions[1].Slug)
+			}
+		}
+	}
+	if e.Tenant && t != nil && t.Slug != nil {
+		labels[model.LabelName("tenant")] = model.LabelValue(*t.Slug)
+	}
+	if e.TenantGroup && t != nil {
+		tenant, tErr := l.tenant(t.ID)
+		if tErr != nil {
+			err = tErr
+		} else if tenant.Group != nil && tenant.Group.Slug != nil {
+			labels[model.LabelName("tenant_group")] = model.LabelValue(*tenant.Group.Slug)
+		}
+	}
+	if e.ClusterGroup && c != nil {
+		cluster, cErr := l.cluster(c.ID)
+		if cErr != nil {
+			err = cErr
+		} else if cluster.Group != nil && cluster.Group.Slug != nil {
+			labels[model.LabelName("cluster_group")] = model.LabelValue(*cluster.Group.Slug)
+		}
+	}
+	return
+}
+
+// regionHierarchy returns the region of the site followed by all its parents
+func (l *netboxLabels) regionHierarchy(siteID int64) (regions []*models.Region, err error) {
+	site, err := l.site(siteID)
+	if err != nil || site.Region == nil {
+		return
+	}
+	id := site.Region.ID
+	for {
+		region, err := l.region(id)
+		if err != nil {
+			return regions, err
+		}
+		regions = append(regions, region)
+		if region.Parent == nil {
+			return regions, nil
+		}
+		id = region.Parent.ID
+	}
+}
+
+func (l *netboxLabels) site(id int64) (*models.Site, error) {
+	l.Lock()
+	site, ok := l.sites[id]
+	l.Unlock()
+	if ok {
+		return site, nil
+	}
+	site, err := l.netbox.Site(id)
+	if err != nil {
+		return nil, err
+	}
+	l.Lock()
+	l.sites[id] = site
+	l.Unlock()
+	return site, nil
+}
+
+// deviceModel returns the manufacturer and model of the device type. Nested device types lacking them
+// are looked up in netbox.
+func (l *netboxLabels) deviceModel(dt *models.NestedDeviceType) (manufacturer, model string, err error) {
+	if dt == nil {
+		return
+	}
+	m, name := dt.Manufacturer, dt.Model
+	if m == nil || m.Name == nil || name == nil {
+		t, err := l.netbox.DeviceType(dt.ID)
+		if err != nil {
+			return "", "", err
+		}
+		m, name = t.Manufacturer, t.Model
+	}
+	if m != nil && m.Name != nil {
+		manufacturer = *m.Name
+	}
+	if name != nil {
+		model = *name
+	}
+	return
+}
+
+func (l *netboxLabels) region(id int64) (*models.Region, error) {
+	l.Lock()
+	region, ok := l.regions[id]
+	l.Unlock()
+	if ok {
+		return region, nil
+	}
+	region, err := l.netbox.Region(id)
+	if err != nil {
+		return nil, err
+	}
+	l.Lock()
+	l.regions[id] = region
+	l.Unlock()
+	return region, nil
+}
+
+func (l *netboxLabels) tenant(id int64) (*models.Tenant, error) {
+	l.Lock()
+	tenant, ok := l.tenants[id]
+	l.Unlock()
+	if ok {
+		return tenant, nil
+	}
+	tenant, err := l.netbox.Tenant(id)
+	if err != nil {
+		return nil, err
+	}
+	l.Lock()
+	l.tenants[id] = tenant
+	l.Unlock()
+	return tenant, nil
+}
+
+func (l *netboxLabels) cluster(id int64) (*models.Cluster, error) {
+	l.Lock()
+	cluster, ok := l.clusters[id]
+	l.Unlock()
+	if ok {
+		return cluster, nil
+	}
+	cluster, err := l.netbox.Cluster(id)
+	if err != nil {
+		return nil, err
+	}
+	l.Lock()
+	l.clusters[id] = cluster
+	l.Unlock()
+	return cluster, nil
+}
+
+func (l *netboxLabels) powerFeed(id int64) (*models.PowerFeed, error) {
+	l.Lock()
+	feed, ok := l.feeds[id]
+	l.Unlock()
+	if ok {
+		return feed, nil
+	}
+	feed, err := l.netbox.PowerFeed(id)
+	if err != nil {
+		return nil, err
+	}
+	l.Lock()
+	l.feeds[id] = feed
+	l.Unlock()
+	return feed, nil
+}
@@ -1,8 +1,11 @@
 package discovery
 
 import (
+	ncircuits "github.com/netbox-community/go-netbox/netbox/client/circuits"
 	ndcim "github.com/netbox-community/go-netbox/netbox/client/dcim"
+	nipam "github.com/netbox-community/go-netbox/netbox/client/ipam"
 	virt "github.com/netbox-community/go-netbox/netbox/client/virtualization"
+	"github.com/sapcc/atlas/pkg/netbox"
 )
 
 const (
@@ -12,10 +15,32 @@ const (
 )
 
 type (
-	customParams struct {
+	// entryParams are common to every query entry. RawQuery is sent as is,
+	// in addition to the query parameters of the generated netbox list params.
+	entryParams struct {
 		CustomLabels map[string]string `yaml:"custom_labels"`
-		Target       int               `yaml:"target"`
 		MetricsLabel string            `yaml:"metrics_label"`
+		RawQuery     netbox.RawQuery   `yaml:"raw_query"`
+	}
+
+	customParams struct {
+		entryParams `yaml:",inline"`
+		Target      int         `yaml:"target"`
+		ExtraLabels extraLabels `yaml:"extra_labels"`
+	}
+
+	// extraLabels switches on additional location and ownership labels
+	extraLabels struct {
+		Rack           bool `yaml:"rack"`
+		RackPosition   bool `yaml:"rack_position"`
+		Face           bool `yaml:"face"`
+		Region         bool `yaml:"region"`
+		Tenant         bool `yaml:"tenant"`
+		TenantGroup    bool `yaml:"tenant_group"`
+		Platform       bool `yaml:"platform"`
+		AssetTag       bool `yaml:"asset_tag"`
+		VirtualChassis bool `yaml:"virtual_chassis"`
+		ClusterGroup   bool `yaml:"cluster_group"`
 	}
 
 	dcimDevice struct {
@@ -28,11 +53,99 @@ type (
 		customParams                                 `yaml:",inline"`
 	}
 
+	// dcimInterface selects the interfaces of the devices found by the Devices query
+	dcimInterface struct {
+		ndcim.DcimInterfacesListParams `yaml:",inline"`
+		customParams                   `yaml:",inline"`
+		Devices                        ndcim.DcimDevicesListParams `yaml:"devices"`
+		// DevicesRawQuery is the raw query of the Devices query, RawQuery applies to the interfaces
+		DevicesRawQuery netbox.RawQuery `yaml:"devices_raw_query"`
+		// IfIndexField is the interface custom field holding the snmp ifIndex, netbox doesn't know it otherwise
+		IfIndexField string `yaml:"if_index_field"`
+	}
+
+	// dcimRack selects the servers in all racks with RackRole in Region
+	dcimRack struct {
+		RackRole     string `yaml:"rack_role"`
+		Region       string `yaml:"region"`
+		customParams `yaml:",inline"`
+	}
+
 	dcim struct {
-		Devices []dcimDevice `yaml:"devices"`
+		Devices    []dcimDevice    `yaml:"devices"`
+		Interfaces []dcimInterface `yaml:"interfaces"`
+		Racks      []dcimRack      `yaml:"racks"`
 	}
 
 	virtualization struct {
 		VMs []virtualizationVM `yaml:"vm"`
 	}
+
+	ipamIPAddress struct {
+		nipam.IpamIPAddressesListParams `yaml:",inline"`
+		entryParams                     `yaml:",inline"`
+	}
+
+	// ipamPrefix emits the ip addresses netbox knows within the prefix,
+	// or every host address of the prefix if ExpandHosts is set.
+	ipamPrefix struct {
+		nipam.IpamPrefixesListParams `yaml:",inline"`
+		entryParams                  `yaml:",inline"`
+		ExpandHosts                  bool `yaml:"expand_hosts"`
+		MaxHosts                     int  `yaml:"max_hosts"`
+	}
+
+	ipamService struct {
+		nipam.IpamServicesListParams `yaml:",inline"`
+		entryParams                  `yaml:",inline"`
+	}
+
+	pdu struct {
+		ndcim.DcimDevicesListParams `yaml:",inline"`
+		customParams                `yaml:",inline"`
+	}
+
+	power struct {
+		PDUs []pdu `yaml:"pdus"`
+	}
+
+	circuit struct {
+		ncircuits.CircuitsCircuitsListParams `yaml:",inline"`
+		entryParams                          `yaml:",inline"`
+	}
+
+	circuitQueries struct {
+		Circuits []circuit `yaml:"circuits"`
+	}
+
+	ipamQueries struct {
+		IPAddresses []ipamIPAddress `yaml:"ip_addresses"`
+		Prefixes    []ipamPrefix    `yaml:"prefixes"`
+		Services    []ipamService   `yaml:"services"`
+	}
+
+	// graphqlQuery runs Query against the netbox graphql api. Results is the path to the objects in the result,
+	// Target and Labels are paths within each object (e.g. "primary_ip4.address" or "site.slug").
+	// RawQuery is not supported, filters belong into the query.
+	graphqlQuery struct {
+		entryParams `yaml:",inline"`
+		Query       string                 `yaml:"query"`
+		Variables   map[string]interface{} `yaml:"variables"`
+		Results     string                 `yaml:"results"`
+		Target      string                 `yaml:"target"`
+		Labels      map[string]string      `yaml:"labels"`
+	}
+
+	// netboxInstance is an additional netbox with its own queries. Its targets are labelled with netbox_instance=Name.
+	// Only dcim and virtualization queries are supported, WebhookSecret defaults to the one of the main netbox.
+	netboxInstance struct {
+		Name           string              `yaml:"name"`
+		NetboxHost     string              `yaml:"netbox_host"`
+		NetboxAPIToken string              `yaml:"netbox_api_token"`
+		NetboxClient   netbox.ClientConfig `yaml:"netbox_client"`
+		CacheTTL       netbox.CacheTTL     `yaml:"cache_ttl"`
+		WebhookSecret  string              `yaml:"webhook_secret"`
+		DCIM           dcim                `yaml:"dcim"`
+		Virtualization virtualization      `yaml:"virtualization"`
+	}
 )
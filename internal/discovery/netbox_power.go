@@ -0,0 +1,114 @@
+package discovery
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/netbox-community/go-netbox/netbox/models"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"gopkg.in/yaml.v2"
+)
+
+func (sd *NetboxDiscovery) loadPDUs(d pdu, groupsCh chan<- []*targetgroup.Group) (err error) {
+	var wg sync.WaitGroup
+	var tgroups []*targetgroup.Group
+	groupCh := make(chan *targetgroup.Group, 0)
+	pdus, err := sd.netbox.PDUsByParams(d.DcimDevicesListParams, d.RawQuery)
+	if err != nil {
+		dout, _ := yaml.Marshal(d.DcimDevicesListParams)
+		return fmt.Errorf("Error loading pdus / Query=%s: %w", string(dout), err)
+	}
+	level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", fmt.Sprintf("found %d pdus", len(pdus)))
+	resolved, err := sd.resolveDeviceIPs(d.Target, pdus)
+	if err != nil {
+		return fmt.Errorf("Error loading pdu ips: %w", err)
+	}
+	// The feeds and outlets of all pdus are loaded at once. Without them the pdus just miss their power labels.
+	ids := make([]int64, 0, len(pdus))
+	for _, dv := range pdus {
+		ids = append(ids, dv.ID)
+	}
+	feedIDs, err := sd.netbox.BulkPowerPortFeedIDs(ids)
+	if err != nil {
+		level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Missing power labels for pdus. Error: %s", err.Error()))
+	}
+	outletDevices, err := sd.netbox.BulkPowerOutletDevices(ids)
+	if err != nil {
+		level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Missing racks of the outlets of pdus. Error: %s", err.Error()))
+	}
+	wg.Add(len(pdus))
+	for _, dv := range pdus {
+		if sd.cfg.RateLimiter > 0 {
+			<-sd.rateLimiter.C
+		}
+		go func(dv models.DeviceWithConfigContext) {
+			p := d.customParams
+			p.CustomLabels = sd.powerLabels(dv, d.CustomLabels, feedIDs[dv.ID], outletDevices[dv.ID])
+			sd.createGroups(p, dv, resolved, &wg, groupCh)
+		}(dv)
+	}
+	go func() {
+		wg.Wait()
+		close(groupCh)
+	}()
+
+	for group := range groupCh {
+		tgroups = append(tgroups, group)
+	}
+	groupsCh <- tgroups
+	return
+}
+
+// powerLabels returns the custom labels extended with the labels of the power feeds connected to the pdu,
+// and the racks of the pdu and the devices connected to its outlets.
+// Pdus with an A and B feed get the values of both feeds, comma separated.
+func (sd *NetboxDiscovery) powerLabels(dv models.DeviceWithConfigContext, c map[string]string, ids []int64, devices []models.DeviceWithConfigContext) map[string]string {
+	labels := make(map[string]string, len(c))
+	for k, v := range c {
+		labels[k] = v
+	}
+	var feeds, panels, phases, voltages, amperages, racks []string
+	if dv.Rack != nil && dv.Rack.Name != nil {
+		racks = append(racks, *dv.Rack.Name)
+	}
+	for _, device := range devices {
+		if device.Rack != nil && device.Rack.Name != nil {
+			racks = append(racks, *device.Rack.Name)
+		}
+	}
+	for _, id := range ids {
+		feed, err := sd.labels.powerFeed(id)
+		if err != nil {
+			level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Missing power feed: %d for pdu: %d. Error: %s", id, dv.ID, err.Error()))
+			continue
+		}
+		if feed.Name != nil {
+			feeds = append(feeds, *feed.Name)
+		}
+		if feed.PowerPanel != nil && feed.PowerPanel.Name != nil {
+			panels = append(panels, *feed.PowerPanel.Name)
+		}
+		if feed.Phase != nil && feed.Phase.Value != nil {
+			phases = append(phases, *feed.Phase.Value)
+		}
+		if feed.Voltage != nil {
+			voltages = append(voltages, strconv.FormatInt(*feed.Voltage, 10))
+		}
+		if feed.Amperage > 0 {
+			amperages = append(amperages, strconv.FormatInt(feed.Amperage, 10))
+		}
+		if feed.Rack != nil && feed.Rack.Name != nil {
+			racks = append(racks, *feed.Rack.Name)
+		}
+	}
+	setJoinedLabel(labels, "power_feed", feeds)
+	setJoinedLabel(labels, "power_panel", panels)
+	setJoinedLabel(labels, "phase", phases)
+	setJoinedLabel(labels, "voltage", voltages)
+	setJoinedLabel(labels, "amperage", amperages)
+	setJoinedLabel(labels, "racks", racks)
+	return labels
+}
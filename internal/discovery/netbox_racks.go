@@ -0,0 +1,51 @@
+package discovery
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/netbox-community/go-netbox/netbox/models"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+func (sd *NetboxDiscovery) loadDcimRacks(r dcimRack, groupsCh chan<- []*targetgroup.Group) (err error) {
+	var tgroups []*targetgroup.Group
+	racks, err := sd.netbox.RacksByRegion(r.RackRole, r.Region, r.RawQuery)
+	if err != nil {
+		return fmt.Errorf("Error loading racks / rack_role=%s region=%s: %w", r.RackRole, r.Region, err)
+	}
+	level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", fmt.Sprintf("found %d racks with role %s", len(racks), r.RackRole))
+	for _, rack := range racks {
+		servers, err := sd.netbox.Servers(strconv.FormatInt(rack.ID, 10))
+		if err != nil {
+			return fmt.Errorf("Error loading servers of rack %d: %w", rack.ID, err)
+		}
+		p := r.customParams
+		p.CustomLabels = rackLabels(rack, r.RackRole, r.CustomLabels)
+		groups, err := sd.createDeviceGroups(p, servers)
+		if err != nil {
+			return err
+		}
+		tgroups = append(tgroups, groups...)
+	}
+	groupsCh <- tgroups
+	return
+}
+
+// rackLabels returns the custom labels extended with the rack, rack role and site of the rack.
+// Configured custom labels take precedence.
+func rackLabels(rack models.Rack, role string, c map[string]string) map[string]string {
+	labels := map[string]string{"rack_role": role}
+	if rack.Name != nil {
+		labels["rack"] = *rack.Name
+	}
+	if rack.Site != nil && rack.Site.Slug != nil {
+		labels["site"] = *rack.Site.Slug
+	}
+	for k, v := range c {
+		labels[k] = v
+	}
+	return labels
+}
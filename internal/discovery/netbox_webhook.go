@@ -0,0 +1,159 @@
+package discovery
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/sapcc/atlas/pkg/netbox"
+)
+
+// maxWebhookSize limits the accepted webhook payload
+const maxWebhookSize = 1 << 20
+
+// webhookModels maps the model names of netbox webhooks to their object types
+var webhookModels = map[string]string{
+	"device":             "dcim.device",
+	"interface":          "dcim.interface",
+	"cable":              "dcim.cable",
+	"powerfeed":          "dcim.powerfeed",
+	"powerport":          "dcim.powerport",
+	"powerpanel":         "dcim.powerpanel",
+	"virtualmachine":     "virtualization.virtualmachine",
+	"vminterface":        "virtualization.vminterface",
+	"ipaddress":          "ipam.ipaddress",
+	"prefix":             "ipam.prefix",
+	"service":            "ipam.service",
+	"circuit":            "circuits.circuit",
+	"circuittermination": "circuits.circuittermination",
+}
+
+// webhook is the payload netbox sends for a changed object. Snapshots are sent by netbox 2.11 and newer.
+type webhook struct {
+	Event     string                 `json:"event"`
+	Model     string                 `json:"model"`
+	Data      map[string]interface{} `json:"data"`
+	Snapshots struct {
+		Prechange  map[string]interface{} `json:"prechange"`
+		Postchange map[string]interface{} `json:"postchange"`
+	} `json:"snapshots"`
+}
+
+// webhookChange is a change sent by the netbox of the discovery nd
+type webhookChange struct {
+	nd     *NetboxDiscovery
+	change netbox.ObjectChange
+}
+
+// HandleWebhook accepts netbox webhooks and hands the change over to Run, which reloads the affected queries.
+// Webhooks of the main netbox are sent to /webhook/netbox, the ones of an instance to /webhook/netbox/<instance>.
+// If the webhook_secret of the netbox is set, the X-Hook-Signature header must hold the hex encoded HMAC-SHA512 of the body.
+func (sd *NetboxDiscovery) HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	nd, ok := sd.webhookInstance(r.URL.Path)
+	if !ok {
+		level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("webhook for unknown netbox instance: %s", r.URL.Path))
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if nd.cfg.WebhookSecret != "" && !validSignature(body, r.Header.Get("X-Hook-Signature"), nd.cfg.WebhookSecret) {
+		level.Error(log.With(sd.logger, "component", "NetboxDiscovery")).Log("error", "invalid webhook signature")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	var hook webhook
+	if err = json.Unmarshal(body, &hook); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	objectType, ok := webhookModels[hook.Model]
+	if !ok {
+		// Not an object any query depends on
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	change := netbox.ObjectChange{ChangedObjectType: objectType, PostchangeData: hook.Data}
+	// The snapshots tell which fields changed, so that the write back of atlas itself can be ignored
+	if hook.Snapshots.Prechange != nil && hook.Snapshots.Postchange != nil {
+		change.PrechangeData, change.PostchangeData = hook.Snapshots.Prechange, hook.Snapshots.Postchange
+	}
+	if id, ok := hook.Data["id"].(float64); ok {
+		change.ChangedObjectID = int64(id)
+	}
+	if change.OnlyCustomFields(nd.cfg.WriteBack.fields()...) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	level.Debug(log.With(nd.logger, "component", "NetboxDiscovery")).Log("debug", fmt.Sprintf("webhook: %s %s %d", hook.Event, objectType, change.ChangedObjectID))
+	select {
+	case sd.webhooks <- webhookChange{nd: nd, change: change}:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+// webhookInstance returns the discovery of the netbox the webhook path belongs to: the main netbox for
+// /webhook/netbox, and the instance for /webhook/netbox/<instance>
+func (sd *NetboxDiscovery) webhookInstance(path string) (*NetboxDiscovery, bool) {
+	name := strings.Trim(strings.TrimPrefix(path, "/webhook/"+netboxDiscovery), "/")
+	if name == "" {
+		return sd, sd.netbox != nil
+	}
+	for _, nd := range sd.netboxes() {
+		if nd.instance == name {
+			return nd, true
+		}
+	}
+	return nil, false
+}
+
+// applyWebhooks applies the change and all other queued webhook changes to their netboxes, and sends the updated groups
+func (sd *NetboxDiscovery) applyWebhooks(change webhookChange, ch chan<- []*targetgroup.Group) {
+	changes := map[*NetboxDiscovery][]netbox.ObjectChange{change.nd: {change.change}}
+	for len(sd.webhooks) > 0 {
+		c := <-sd.webhooks
+		changes[c.nd] = append(changes[c.nd], c.change)
+	}
+	applied := false
+	for _, nd := range sd.netboxes() {
+		// Without a first full load there is nothing to patch, the next load includes the changes anyway
+		if len(changes[nd]) == 0 || nd.groups == nil {
+			continue
+		}
+		if err := nd.applyChanges(changes[nd]); err != nil {
+			level.Error(log.With(nd.logger, "component", "NetboxDiscovery")).Log("error", fmt.Errorf("Error applying webhook changes: %w", err))
+			continue
+		}
+		applied = true
+	}
+	if applied {
+		ch <- sd.allGroups()
+	}
+}
+
+func validSignature(body []byte, signature, secret string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha512.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
@@ -0,0 +1,109 @@
+package discovery
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/sapcc/atlas/pkg/netbox"
+)
+
+func sign(body, secret string) string {
+	mac := hmac.New(sha512.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidSignature(t *testing.T) {
+	body := `{"event":"updated","model":"device"}`
+	tests := []struct {
+		name      string
+		signature string
+		want      bool
+	}{
+		{"valid", sign(body, "secret"), true},
+		{"upper case hex", strings.ToUpper(sign(body, "secret")), true},
+		{"other secret", sign(body, "other"), false},
+		{"other body", sign(body+" ", "secret"), false},
+		{"not hex", "signature", false},
+		{"missing", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validSignature([]byte(body), tt.signature, "secret"); got != tt.want {
+				t.Errorf("validSignature() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleWebhook(t *testing.T) {
+	instance := &NetboxDiscovery{
+		netbox:   &netbox.Netbox{},
+		instance: "eu",
+		cfg:      netboxConfig{WebhookSecret: "eu-secret"},
+		logger:   log.NewNopLogger(),
+	}
+	sd := &NetboxDiscovery{
+		netbox:    &netbox.Netbox{},
+		cfg:       netboxConfig{WebhookSecret: "secret", WriteBack: writeBackConfig{MonitoredField: "monitored"}},
+		logger:    log.NewNopLogger(),
+		webhooks:  make(chan webhookChange, 1),
+		instances: []*NetboxDiscovery{instance},
+	}
+	device := `{"event":"updated","model":"device","data":{"id":7}}`
+	writeBack := `{"event":"updated","model":"device","data":{"id":7},"snapshots":{` +
+		`"prechange":{"id":7,"custom_fields":{"monitored":null}},"postchange":{"id":7,"custom_fields":{"monitored":"snmp"}}}}`
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		secret string
+		code   int
+		queued *NetboxDiscovery
+	}{
+		{name: "get", method: http.MethodGet, path: "/webhook/netbox", code: http.StatusMethodNotAllowed},
+		{name: "unknown instance", path: "/webhook/netbox/us", body: device, secret: "secret", code: http.StatusNotFound},
+		{name: "invalid signature", path: "/webhook/netbox", body: device, secret: "eu-secret", code: http.StatusForbidden},
+		{name: "invalid json", path: "/webhook/netbox", body: "{", secret: "secret", code: http.StatusBadRequest},
+		{name: "unused model", path: "/webhook/netbox", body: `{"model":"tag","data":{"id":1}}`, secret: "secret", code: http.StatusAccepted},
+		{name: "write back", path: "/webhook/netbox", body: writeBack, secret: "secret", code: http.StatusAccepted},
+		{name: "main netbox", path: "/webhook/netbox", body: device, secret: "secret", code: http.StatusAccepted, queued: sd},
+		{name: "instance", path: "/webhook/netbox/eu/", body: device, secret: "eu-secret", code: http.StatusAccepted, queued: instance},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			r := httptest.NewRequest(method, tt.path, strings.NewReader(tt.body))
+			r.Header.Set("X-Hook-Signature", sign(tt.body, tt.secret))
+			w := httptest.NewRecorder()
+			sd.HandleWebhook(w, r)
+			if w.Code != tt.code {
+				t.Errorf("code = %d, want %d", w.Code, tt.code)
+			}
+			select {
+			case c := <-sd.webhooks:
+				if c.nd != tt.queued {
+					t.Errorf("change queued for the wrong netbox")
+				}
+				if c.change.ChangedObjectType != "dcim.device" || c.change.ChangedObjectID != 7 {
+					t.Errorf("queued change %s %d, want dcim.device 7", c.change.ChangedObjectType, c.change.ChangedObjectID)
+				}
+			default:
+				if tt.queued != nil {
+					t.Errorf("no change queued")
+				}
+			}
+		})
+	}
+}
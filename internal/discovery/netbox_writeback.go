@@ -0,0 +1,182 @@
+package discovery
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/sapcc/atlas/pkg/errgroup"
+	"github.com/sapcc/atlas/pkg/netbox"
+)
+
+// writeBackConfig names the netbox custom fields set on the devices and vms turned into targets.
+// Write back is disabled if no monitored_field is configured.
+type writeBackConfig struct {
+	// MonitoredField is set to the jobs of the targets of the object, and cleared once it isn't a target anymore
+	MonitoredField string `yaml:"monitored_field"`
+	// LastSeenField is set to the time of the last run which found the object, optional
+	LastSeenField string `yaml:"last_seen_field"`
+	// LastSeenInterval are the seconds between two updates of the last seen field of an otherwise unchanged object
+	LastSeenInterval int `yaml:"last_seen_interval"`
+}
+
+// defaultLastSeenInterval is used if last_seen_interval is not set. Every update shows up in the change log of
+// netbox and triggers its webhooks, so the last seen field of unchanged objects is only refreshed once a day.
+const defaultLastSeenInterval = 24 * time.Hour
+
+// writtenObject is what was last written back to an object
+type writtenObject struct {
+	jobs     string
+	lastSeen time.Time
+}
+
+// fields returns the custom fields written back
+func (c writeBackConfig) fields() (fields []string) {
+	if c.MonitoredField == "" {
+		return
+	}
+	fields = append(fields, c.MonitoredField)
+	if c.LastSeenField != "" {
+		fields = append(fields, c.LastSeenField)
+	}
+	return
+}
+
+func (c writeBackConfig) lastSeenInterval() time.Duration {
+	if c.LastSeenInterval <= 0 {
+		return defaultLastSeenInterval
+	}
+	return time.Duration(c.LastSeenInterval) * time.Second
+}
+
+// writeBack updates the custom fields of the objects of all netbox instances, after a successful run.
+// Only objects with targets left after the deduplication count as monitored.
+func (sd *NetboxDiscovery) writeBack() error {
+	var eg errgroup.Group
+	now := time.Now()
+	queries := sd.allQueryGroups()
+	dropped, _ := sd.duplicateTargets(queries)
+	for _, nd := range sd.netboxes() {
+		func(nd *NetboxDiscovery) {
+			eg.Go(func() error {
+				devices, vms := nd.monitoredObjects(queries, dropped)
+				return nd.writeBackInstance(sd.cfg.WriteBack, devices, vms, now)
+			})
+		}(nd)
+	}
+	return eg.Wait()
+}
+
+func (sd *NetboxDiscovery) writeBackInstance(cfg writeBackConfig, devices, vms map[int64]string, now time.Time) (err error) {
+	// The objects written by a previous atlas are read from netbox, so that the ones dropped since then get cleared
+	if sd.writtenDevices == nil {
+		fields, err := sd.netbox.DeviceCustomFields(cfg.MonitoredField)
+		if err != nil {
+			return fmt.Errorf("Error loading written back devices: %w", err)
+		}
+		sd.writtenDevices = writtenObjects(cfg, fields)
+	}
+	if sd.writtenVMs == nil {
+		fields, err := sd.netbox.VMCustomFields(cfg.MonitoredField)
+		if err != nil {
+			return fmt.Errorf("Error loading written back vms: %w", err)
+		}
+		sd.writtenVMs = writtenObjects(cfg, fields)
+	}
+	deviceUpdates, written := writeBackUpdates(cfg, devices, sd.writtenDevices, now)
+	if err := sd.netbox.UpdateDeviceCustomFields(deviceUpdates); err != nil {
+		return fmt.Errorf("Error writing back devices: %w", err)
+	}
+	sd.writtenDevices = written
+	vmUpdates, written := writeBackUpdates(cfg, vms, sd.writtenVMs, now)
+	if err := sd.netbox.UpdateVMCustomFields(vmUpdates); err != nil {
+		return fmt.Errorf("Error writing back vms: %w", err)
+	}
+	sd.writtenVMs = written
+	level.Debug(log.With(sd.logger, "component", "NetboxDiscovery")).Log("debug", fmt.Sprintf("wrote back %d devices and %d vms", len(deviceUpdates), len(vmUpdates)))
+	return nil
+}
+
+// monitoredObjects returns the jobs (or else metrics labels) of the targets of the netbox per device id and vm id,
+// comma separated. Targets dropped as duplicates are left out.
+func (sd *NetboxDiscovery) monitoredObjects(queries []queryGroups, dropped map[*targetgroup.Group]map[int]*targetgroup.Group) (devices, vms map[int64]string) {
+	deviceJobs := make(map[int64][]string)
+	vmJobs := make(map[int64][]string)
+	for _, q := range queries {
+		if q.nd != sd || !q.query.devices && !q.query.vms {
+			continue
+		}
+		for _, group := range q.groups {
+			id := groupServerID(group)
+			if id == 0 || len(group.Targets)-len(dropped[group]) == 0 {
+				continue
+			}
+			job := string(group.Labels[model.JobLabel])
+			if job == "" {
+				job = string(group.Labels[model.LabelName("metrics_label")])
+			}
+			if q.query.vms {
+				vmJobs[id] = append(vmJobs[id], job)
+			} else {
+				deviceJobs[id] = append(deviceJobs[id], job)
+			}
+		}
+	}
+	return joinedJobs(deviceJobs), joinedJobs(vmJobs)
+}
+
+func joinedJobs(jobs map[int64][]string) map[int64]string {
+	joined := make(map[int64]string, len(jobs))
+	for id, j := range jobs {
+		joined[id] = joinedValues(j)
+	}
+	return joined
+}
+
+// writtenObjects returns the write back state of the objects read from netbox by their custom fields
+func writtenObjects(cfg writeBackConfig, fields map[int64]map[string]interface{}) map[int64]writtenObject {
+	written := make(map[int64]writtenObject, len(fields))
+	for id, f := range fields {
+		var o writtenObject
+		o.jobs, _ = f[cfg.MonitoredField].(string)
+		if seen, ok := f[cfg.LastSeenField].(string); ok && cfg.LastSeenField != "" {
+			o.lastSeen, _ = time.Parse(time.RFC3339, seen)
+		}
+		written[id] = o
+	}
+	return written
+}
+
+// writeBackUpdates sets the monitored field (and last seen field) of the current objects and clears the monitored field
+// of the previously written objects which aren't current anymore. Unchanged objects are skipped, unless their last
+// seen field is older than the last seen interval. It returns the updates and the written state after them.
+func writeBackUpdates(cfg writeBackConfig, current map[int64]string, previous map[int64]writtenObject, now time.Time) (updates []netbox.CustomFields, written map[int64]writtenObject) {
+	written = make(map[int64]writtenObject, len(current))
+	for id, jobs := range current {
+		prev, ok := previous[id]
+		stale := cfg.LastSeenField != "" && now.Sub(prev.lastSeen) >= cfg.lastSeenInterval()
+		if ok && prev.jobs == jobs && !stale {
+			written[id] = prev
+			continue
+		}
+		o := writtenObject{jobs: jobs, lastSeen: prev.lastSeen}
+		fields := map[string]interface{}{cfg.MonitoredField: jobs}
+		if cfg.LastSeenField != "" {
+			o.lastSeen = now.UTC().Truncate(time.Second)
+			fields[cfg.LastSeenField] = o.lastSeen.Format(time.RFC3339)
+		}
+		written[id] = o
+		updates = append(updates, netbox.CustomFields{ID: id, CustomFields: fields})
+	}
+	for id := range previous {
+		if _, ok := current[id]; !ok {
+			updates = append(updates, netbox.CustomFields{ID: id, CustomFields: map[string]interface{}{cfg.MonitoredField: nil}})
+		}
+	}
+	sort.Slice(updates, func(i, j int) bool { return updates[i].ID < updates[j].ID })
+	return
+}
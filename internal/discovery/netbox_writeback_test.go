@@ -0,0 +1,112 @@
+package discovery
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sapcc/atlas/pkg/netbox"
+)
+
+func TestWriteBackUpdates(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	seen := now.Format(time.RFC3339)
+	recent := now.Add(-time.Hour)
+	old := now.Add(-25 * time.Hour)
+	monitored := writeBackConfig{MonitoredField: "monitored"}
+	lastSeen := writeBackConfig{MonitoredField: "monitored", LastSeenField: "seen"}
+
+	tests := []struct {
+		name     string
+		cfg      writeBackConfig
+		current  map[int64]string
+		previous map[int64]writtenObject
+		updates  []netbox.CustomFields
+		written  map[int64]writtenObject
+	}{
+		{
+			name:     "unchanged",
+			cfg:      monitored,
+			current:  map[int64]string{1: "snmp"},
+			previous: map[int64]writtenObject{1: {jobs: "snmp"}},
+			written:  map[int64]writtenObject{1: {jobs: "snmp"}},
+		},
+		{
+			name:     "new, changed and dropped",
+			cfg:      monitored,
+			current:  map[int64]string{1: "ipmi,snmp", 2: "snmp"},
+			previous: map[int64]writtenObject{1: {jobs: "snmp"}, 3: {jobs: "snmp"}},
+			updates: []netbox.CustomFields{
+				{ID: 1, CustomFields: map[string]interface{}{"monitored": "ipmi,snmp"}},
+				{ID: 2, CustomFields: map[string]interface{}{"monitored": "snmp"}},
+				{ID: 3, CustomFields: map[string]interface{}{"monitored": nil}},
+			},
+			written: map[int64]writtenObject{1: {jobs: "ipmi,snmp"}, 2: {jobs: "snmp"}},
+		},
+		{
+			name:     "last seen within the interval",
+			cfg:      lastSeen,
+			current:  map[int64]string{1: "snmp"},
+			previous: map[int64]writtenObject{1: {jobs: "snmp", lastSeen: recent}},
+			written:  map[int64]writtenObject{1: {jobs: "snmp", lastSeen: recent}},
+		},
+		{
+			name:     "last seen outdated",
+			cfg:      lastSeen,
+			current:  map[int64]string{1: "snmp"},
+			previous: map[int64]writtenObject{1: {jobs: "snmp", lastSeen: old}},
+			updates: []netbox.CustomFields{
+				{ID: 1, CustomFields: map[string]interface{}{"monitored": "snmp", "seen": seen}},
+			},
+			written: map[int64]writtenObject{1: {jobs: "snmp", lastSeen: now}},
+		},
+		{
+			name:     "last seen with a shorter interval",
+			cfg:      writeBackConfig{MonitoredField: "monitored", LastSeenField: "seen", LastSeenInterval: 600},
+			current:  map[int64]string{1: "snmp"},
+			previous: map[int64]writtenObject{1: {jobs: "snmp", lastSeen: recent}},
+			updates: []netbox.CustomFields{
+				{ID: 1, CustomFields: map[string]interface{}{"monitored": "snmp", "seen": seen}},
+			},
+			written: map[int64]writtenObject{1: {jobs: "snmp", lastSeen: now}},
+		},
+		{
+			name:     "changed within the last seen interval",
+			cfg:      lastSeen,
+			current:  map[int64]string{1: "ipmi"},
+			previous: map[int64]writtenObject{1: {jobs: "snmp", lastSeen: recent}},
+			updates: []netbox.CustomFields{
+				{ID: 1, CustomFields: map[string]interface{}{"monitored": "ipmi", "seen": seen}},
+			},
+			written: map[int64]writtenObject{1: {jobs: "ipmi", lastSeen: now}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updates, written := writeBackUpdates(tt.cfg, tt.current, tt.previous, now)
+			if !reflect.DeepEqual(updates, tt.updates) {
+				t.Errorf("updates = %v, want %v", updates, tt.updates)
+			}
+			if !reflect.DeepEqual(written, tt.written) {
+				t.Errorf("written = %v, want %v", written, tt.written)
+			}
+		})
+	}
+}
+
+func TestWrittenObjects(t *testing.T) {
+	cfg := writeBackConfig{MonitoredField: "monitored", LastSeenField: "seen"}
+	fields := map[int64]map[string]interface{}{
+		1: {"monitored": "snmp", "seen": "2021-03-01T12:00:00Z"},
+		2: {"monitored": "ipmi", "seen": nil},
+		3: {"monitored": "ipmi", "seen": "yesterday"},
+	}
+	want := map[int64]writtenObject{
+		1: {jobs: "snmp", lastSeen: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)},
+		2: {jobs: "ipmi"},
+		3: {jobs: "ipmi"},
+	}
+	if got := writtenObjects(cfg, fields); !reflect.DeepEqual(got, want) {
+		t.Errorf("writtenObjects() = %v, want %v", got, want)
+	}
+}
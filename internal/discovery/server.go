@@ -11,16 +11,18 @@ import (
 )
 
 type Server struct {
-	adapter   []adapter.Adapter
-	discovery []Discovery
-	logger    log.Logger
+	adapter    []adapter.Adapter
+	discovery  []Discovery
+	duplicates *DuplicateAddresses
+	logger     log.Logger
 }
 
-func NewServer(a []adapter.Adapter, d []Discovery, l log.Logger) *Server {
+func NewServer(a []adapter.Adapter, d []Discovery, dup *DuplicateAddresses, l log.Logger) *Server {
 	return &Server{
-		adapter:   a,
-		discovery: d,
-		logger:    l,
+		adapter:    a,
+		discovery:  d,
+		duplicates: dup,
+		logger:     l,
 	}
 }
 
@@ -28,7 +30,15 @@ func (s *Server) Start() {
 	http.Handle("/metrics", promhttp.Handler())
 	for _, d := range s.discovery {
 		http.HandleFunc("/service_discovery/"+d.GetName(), s.serviceDiscovery(d))
+		if wh, ok := d.(WebhookHandler); ok {
+			http.HandleFunc("/webhook/"+d.GetName(), wh.HandleWebhook)
+			http.HandleFunc("/webhook/"+d.GetName()+"/", wh.HandleWebhook)
+		}
+		if dh, ok := d.(DriftHandler); ok {
+			http.HandleFunc("/drift/"+d.GetName(), dh.HandleDrift)
+		}
 	}
+	http.Handle("/duplicates", s.duplicates)
 	http.HandleFunc("/healthz", s.health)
 	if err := http.ListenAndServe(":8080", nil); err != nil {
 		panic(err)
@@ -1,5 +1,12 @@
 package discovery
 
+import (
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
 func setMetricsLabelAndValue(t map[string]int, l string, i int) {
 	if l != "" {
 		if val, ok := t[l]; ok {
@@ -9,3 +16,32 @@ func setMetricsLabelAndValue(t map[string]int, l string, i int) {
 		}
 	}
 }
+
+func customLabels(c map[string]string) model.LabelSet {
+	labels := model.LabelSet{}
+	for k, v := range c {
+		labels[model.LabelName(k)] = model.LabelValue(v)
+	}
+	return labels
+}
+
+// joinedValues returns the sorted, unique values comma separated
+func joinedValues(values []string) string {
+	unique := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	sort.Strings(unique)
+	return strings.Join(unique, ",")
+}
+
+// setJoinedLabel sets the joined values, if there are any
+func setJoinedLabel(labels map[string]string, name string, values []string) {
+	if len(values) > 0 {
+		labels[name] = joinedValues(values)
+	}
+}
@@ -0,0 +1,503 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/sapcc/atlas/pkg/adapter"
+	"github.com/sapcc/atlas/pkg/clients"
+	"github.com/sapcc/atlas/pkg/config"
+	"github.com/sapcc/atlas/pkg/writer"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const vsphereDiscovery = "vsphere"
+
+const (
+	vsphereHosts = "hosts"
+	vsphereVMs   = "vms"
+)
+
+// vsphereTagRefresh is the interval the tag names are resolved to tag ids again, picking up renamed or new tags
+const vsphereTagRefresh = time.Hour
+
+type (
+	VSphereDiscovery struct {
+		cfg             vsphereConfig
+		adapter         adapter.Adapter
+		client          *clients.VSphereClient
+		refreshInterval int
+		logger          log.Logger
+		status          *Status
+		outputFile      string
+		metricsLabel    string
+		// tagIDs are the ids of the configured tag names, resolved at tagsResolved
+		tagIDs       map[string][]string
+		tagsResolved time.Time
+	}
+
+	vsphereConfig struct {
+		// URL of the vCenter, e.g. https://vcenter.example.com
+		URL                string `yaml:"url"`
+		User               string `yaml:"user"`
+		Password           string `yaml:"password"`
+		CACert             string `yaml:"ca_cert"`
+		InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+		// Timeout of a single request in seconds, defaults to 30
+		Timeout int `yaml:"timeout"`
+		// Objects to emit: hosts and/or vms, defaults to both
+		Objects []string `yaml:"objects"`
+		// Datacenters and Clusters are names. Hosts and vms in any of them are emitted.
+		Datacenters []string `yaml:"datacenters"`
+		Clusters    []string `yaml:"clusters"`
+		// VMFolders and HostFolders are folder names. Only the vms and hosts below any of them are emitted.
+		VMFolders   []string `yaml:"vm_folders"`
+		HostFolders []string `yaml:"host_folders"`
+		// Tags are tag names. Only objects with all of them attached are emitted.
+		Tags []string `yaml:"tags"`
+		// PowerStates are POWERED_ON, POWERED_OFF, SUSPENDED (vms) or STANDBY (hosts), defaults to POWERED_ON
+		PowerStates []string `yaml:"power_states"`
+		// Port is added to the host names and vm ips if set
+		Port            int               `yaml:"port"`
+		RefreshInterval int               `yaml:"refresh_interval"`
+		TargetsFileName string            `yaml:"targets_file_name"`
+		MetricsLabel    string            `yaml:"metrics_label"`
+		CustomLabels    map[string]string `yaml:"custom_labels"`
+		ConfigmapName   string            `yaml:"configmap_name"`
+	}
+
+	// vsphereInventory holds the folders, datacenters, clusters and hosts by their ids, the hosts with their
+	// states and the datastore names
+	vsphereInventory struct {
+		entities   map[string]mo.ManagedEntity
+		hosts      []mo.HostSystem
+		datastores map[string]string
+	}
+)
+
+// vsphereStates maps the power and connection states of the vim25 api to the ones of the REST api
+var vsphereStates = map[string]string{
+	"poweredOn":     "POWERED_ON",
+	"poweredOff":    "POWERED_OFF",
+	"suspended":     "SUSPENDED",
+	"standBy":       "STANDBY",
+	"unknown":       "UNKNOWN",
+	"connected":     "CONNECTED",
+	"disconnected":  "DISCONNECTED",
+	"notResponding": "NOT_RESPONDING",
+}
+
+func init() {
+	Register(vsphereDiscovery, NewVSphereDiscovery)
+}
+
+// NewVSphereDiscovery creates a new vSphere Discovery
+func NewVSphereDiscovery(disc interface{}, ctx context.Context, opts config.Options, l log.Logger) (d Discovery, err error) {
+	var cfg vsphereConfig
+	if err := UnmarshalHandler(disc, &cfg, nil); err != nil {
+		return d, err
+	}
+	if len(cfg.Objects) == 0 {
+		cfg.Objects = []string{vsphereHosts, vsphereVMs}
+	}
+	for _, o := range cfg.Objects {
+		if o != vsphereHosts && o != vsphereVMs {
+			return d, fmt.Errorf("invalid vsphere object %s, must be one of: %s, %s", o, vsphereHosts, vsphereVMs)
+		}
+	}
+	if len(cfg.PowerStates) == 0 {
+		cfg.PowerStates = []string{"POWERED_ON"}
+	}
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	client, err := clients.NewVSphereClient(cfg.URL, cfg.User, cfg.Password, cfg.CACert, cfg.InsecureSkipVerify, timeout)
+	if err != nil {
+		level.Error(log.With(l, "component", "VSphereDiscovery")).Log("err", err)
+		return d, err
+	}
+
+	var w writer.Writer
+	if cfg.ConfigmapName != "" {
+		w, err = writer.NewConfigMap(cfg.ConfigmapName, opts.NameSpace, l)
+	} else {
+		w, err = writer.NewFile(cfg.TargetsFileName, l)
+	}
+
+	a := adapter.NewPrometheus(ctx, cfg.TargetsFileName, w, l)
+
+	return &VSphereDiscovery{
+		cfg:             cfg,
+		adapter:         a,
+		client:          client,
+		refreshInterval: cfg.RefreshInterval,
+		logger:          l,
+		status:          &Status{Up: false, Targets: make(map[string]int)},
+		outputFile:      cfg.TargetsFileName,
+		metricsLabel:    cfg.MetricsLabel,
+	}, nil
+}
+
+func (d *VSphereDiscovery) Run(ctx context.Context, ch chan<- []*targetgroup.Group) {
+	for c := time.Tick(time.Duration(d.refreshInterval) * time.Second); ; {
+		tgs, err := d.loadObjects(ctx)
+		d.status.Lock()
+		d.status.Up = err == nil
+		d.status.Unlock()
+		if err == nil {
+			level.Debug(log.With(d.logger, "component", "VSphereDiscovery")).Log("debug", "Done Loading Hosts and VMs")
+			ch <- tgs
+		} else {
+			level.Error(log.With(d.logger, "component", "VSphereDiscovery")).Log("error", err)
+		}
+		// Wait for ticker or exit when ctx is closed.
+		select {
+		case <-c:
+			continue
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *VSphereDiscovery) GetAdapter() adapter.Adapter {
+	return d.adapter
+}
+
+func (d *VSphereDiscovery) Up() bool {
+	return d.status.Up
+}
+
+func (d *VSphereDiscovery) Targets() map[string]int {
+	d.status.Targets = make(map[string]int)
+	setMetricsLabelAndValue(d.status.Targets, d.metricsLabel, d.adapter.GetNumberOfTargetsFor(d.metricsLabel))
+	return d.status.Targets
+}
+
+func (d *VSphereDiscovery) Lock() {
+	d.status.Lock()
+}
+
+func (d *VSphereDiscovery) Unlock() {
+	d.status.Unlock()
+}
+
+func (d *VSphereDiscovery) GetOutputFile() string {
+	return d.outputFile
+}
+
+func (d *VSphereDiscovery) GetName() string {
+	return vsphereDiscovery
+}
+
+func (d *VSphereDiscovery) loadObjects(ctx context.Context) (tgroups []*targetgroup.Group, err error) {
+	inv, err := d.inventory(ctx)
+	if err != nil {
+		return
+	}
+	tagged, err := d.taggedObjects(ctx)
+	if err != nil {
+		return
+	}
+	for _, o := range d.cfg.Objects {
+		var groups []*targetgroup.Group
+		if o == vsphereHosts {
+			groups = d.loadHosts(inv, tagged)
+		} else {
+			groups, err = d.loadVMs(ctx, inv, tagged)
+		}
+		if err != nil {
+			return nil, err
+		}
+		tgroups = append(tgroups, groups...)
+	}
+	return
+}
+
+// inventory loads the folders, datacenters, clusters and hosts, and checks that the configured names exist.
+// The vim25 api returns the parent of each object, which tells the location of the hosts and vms.
+func (d *VSphereDiscovery) inventory(ctx context.Context) (inv vsphereInventory, err error) {
+	inv = vsphereInventory{entities: make(map[string]mo.ManagedEntity), datastores: make(map[string]string)}
+
+	var entities []mo.ManagedEntity
+	err = d.client.Retrieve(ctx, []string{"Folder", "Datacenter", "ComputeResource", "ClusterComputeResource"}, []string{"name", "parent"}, &entities)
+	if err != nil {
+		return inv, fmt.Errorf("Error loading vsphere inventory: %w", err)
+	}
+	err = d.client.Retrieve(ctx, []string{"HostSystem"}, []string{"name", "parent", "runtime.connectionState", "runtime.powerState"}, &inv.hosts)
+	if err != nil {
+		return inv, fmt.Errorf("Error loading vsphere hosts: %w", err)
+	}
+	level.Debug(log.With(d.logger, "component", "VSphereDiscovery")).Log("debug", fmt.Sprintf("found %d hosts", len(inv.hosts)))
+	for _, e := range entities {
+		inv.entities[e.Self.Value] = e
+	}
+	for _, h := range inv.hosts {
+		inv.entities[h.Self.Value] = h.ManagedEntity
+	}
+	for _, n := range []struct {
+		what, objectType string
+		names            []string
+	}{
+		{"datacenters", "Datacenter", d.cfg.Datacenters},
+		{"clusters", "ClusterComputeResource", d.cfg.Clusters},
+		{"vm folders", "Folder", d.cfg.VMFolders},
+		{"host folders", "Folder", d.cfg.HostFolders},
+	} {
+		if len(n.names) > 0 && !inv.found(n.objectType, n.names) {
+			return inv, fmt.Errorf("none of the vsphere %s %s found", n.what, strings.Join(n.names, ", "))
+		}
+	}
+
+	if !vsphereContains(d.cfg.Objects, vsphereVMs) {
+		return
+	}
+	var datastores []mo.Datastore
+	if err = d.client.Retrieve(ctx, []string{"Datastore"}, []string{"name"}, &datastores); err != nil {
+		return inv, fmt.Errorf("Error loading vsphere datastores: %w", err)
+	}
+	for _, ds := range datastores {
+		inv.datastores[ds.Self.Value] = ds.Name
+	}
+	return
+}
+
+// taggedObjects returns the ids of the objects with all configured tags attached, nil if no tags are configured.
+// The tag names are resolved to tag ids every vsphereTagRefresh, and again after errors.
+func (d *VSphereDiscovery) taggedObjects(ctx context.Context) (map[string]bool, error) {
+	if len(d.cfg.Tags) == 0 {
+		return nil, nil
+	}
+	if d.tagIDs == nil || time.Since(d.tagsResolved) > vsphereTagRefresh {
+		ids, err := d.client.TagIDs(ctx, d.cfg.Tags)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading vsphere tags: %w", err)
+		}
+		d.tagIDs, d.tagsResolved = ids, time.Now()
+	}
+	var all []string
+	for _, name := range d.cfg.Tags {
+		all = append(all, d.tagIDs[name]...)
+	}
+	objects, err := d.client.TaggedObjects(ctx, all)
+	if err != nil {
+		d.tagIDs = nil
+		return nil, fmt.Errorf("Error loading objects of vsphere tags: %w", err)
+	}
+	counts := make(map[string]int)
+	for _, name := range d.cfg.Tags {
+		found := make(map[string]bool)
+		for _, id := range d.tagIDs[name] {
+			for _, o := range objects[id] {
+				found[o] = true
+			}
+		}
+		for id := range found {
+			counts[id]++
+		}
+	}
+	tagged := make(map[string]bool)
+	for id, count := range counts {
+		if count == len(d.cfg.Tags) {
+			tagged[id] = true
+		}
+	}
+	return tagged, nil
+}
+
+func (d *VSphereDiscovery) loadHosts(inv vsphereInventory, tagged map[string]bool) (tgroups []*targetgroup.Group) {
+	for _, h := range inv.hosts {
+		id := h.Self.Value
+		if !d.hostSelected(inv, id) || !inv.inFolder(id, d.cfg.HostFolders) {
+			continue
+		}
+		powerState := vsphereStates[string(h.Runtime.PowerState)]
+		if (tagged != nil && !tagged[id]) || !d.powerStateSelected(powerState) {
+			continue
+		}
+		labels := model.LabelSet{
+			model.LabelName("server_name"):      model.LabelValue(h.Name),
+			model.LabelName("server_id"):        model.LabelValue(id),
+			model.LabelName("host"):             model.LabelValue(h.Name),
+			model.LabelName("type"):             model.LabelValue("esxi_host"),
+			model.LabelName("power_state"):      model.LabelValue(strings.ToLower(powerState)),
+			model.LabelName("connection_state"): model.LabelValue(strings.ToLower(vsphereStates[string(h.Runtime.ConnectionState)])),
+			model.LabelName("metrics_label"):    model.LabelValue(d.metricsLabel),
+		}
+		inv.setLabels(labels, id)
+		tgroups = append(tgroups, &targetgroup.Group{
+			Source:  "host/" + id,
+			Labels:  labels.Merge(customLabels(d.cfg.CustomLabels)),
+			Targets: []model.LabelSet{{model.AddressLabel: model.LabelValue(d.address(h.Name))}},
+		})
+	}
+	return
+}
+
+// loadVMs emits the guest ip of the vms, as reported by the VMware tools. VMs without running tools or guest ip are skipped.
+// The datacenter and cluster of a vm are the ones of its host.
+func (d *VSphereDiscovery) loadVMs(ctx context.Context, inv vsphereInventory, tagged map[string]bool) (tgroups []*targetgroup.Group, err error) {
+	var vms []mo.VirtualMachine
+	err = d.client.Retrieve(ctx, []string{"VirtualMachine"}, []string{
+		"name", "parent", "datastore", "runtime.powerState", "runtime.host", "config.template", "config.guestFullName",
+		"guest.toolsRunningStatus", "guest.guestFullName", "guest.hostName", "guest.ipAddress",
+	}, &vms)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading vsphere vms: %w", err)
+	}
+	level.Debug(log.With(d.logger, "component", "VSphereDiscovery")).Log("debug", fmt.Sprintf("found %d vms", len(vms)))
+	for _, vm := range vms {
+		var host, parent string
+		if vm.Runtime.Host != nil {
+			host = vm.Runtime.Host.Value
+		}
+		if vm.Parent != nil {
+			parent = vm.Parent.Value
+		}
+		if (vm.Config != nil && vm.Config.Template) || !d.hostSelected(inv, host) || !inv.inFolder(parent, d.cfg.VMFolders) {
+			continue
+		}
+		powerState := vsphereStates[string(vm.Runtime.PowerState)]
+		if (tagged != nil && !tagged[vm.Self.Value]) || !d.powerStateSelected(powerState) {
+			continue
+		}
+		if vm.Guest == nil || vm.Guest.ToolsRunningStatus != string(types.VirtualMachineToolsRunningStatusGuestToolsRunning) {
+			level.Debug(log.With(d.logger, "component", "VSphereDiscovery")).Log("debug", fmt.Sprintf("ignoring vm %s: VMware tools not running", vm.Name))
+			continue
+		}
+		if vm.Guest.IpAddress == "" {
+			level.Debug(log.With(d.logger, "component", "VSphereDiscovery")).Log("debug", fmt.Sprintf("ignoring vm %s: no guest ip", vm.Name))
+			continue
+		}
+		tgroups = append(tgroups, d.createVMGroup(vm, host, powerState, inv))
+	}
+	return
+}
+
+func (d *VSphereDiscovery) createVMGroup(vm mo.VirtualMachine, host, powerState string, inv vsphereInventory) *targetgroup.Group {
+	guestOS := vm.Guest.GuestFullName
+	if guestOS == "" && vm.Config != nil {
+		guestOS = vm.Config.GuestFullName
+	}
+	labels := model.LabelSet{
+		model.LabelName("server_name"):   model.LabelValue(vm.Name),
+		model.LabelName("server_id"):     model.LabelValue(vm.Self.Value),
+		model.LabelName("type"):          model.LabelValue("vm"),
+		model.LabelName("host"):          model.LabelValue(inv.entities[host].Name),
+		model.LabelName("power_state"):   model.LabelValue(strings.ToLower(powerState)),
+		model.LabelName("guest_os"):      model.LabelValue(guestOS),
+		model.LabelName("metrics_label"): model.LabelValue(d.metricsLabel),
+	}
+	if vm.Guest.HostName != "" {
+		labels[model.LabelName("guest_hostname")] = model.LabelValue(vm.Guest.HostName)
+	}
+	var datastores []string
+	for _, ds := range vm.Datastore {
+		if name := inv.datastores[ds.Value]; name != "" {
+			datastores = append(datastores, name)
+		}
+	}
+	if len(datastores) > 0 {
+		labels[model.LabelName("datastore")] = model.LabelValue(joinedValues(datastores))
+	}
+	inv.setLabels(labels, host)
+	return &targetgroup.Group{
+		Source:  "vm/" + vm.Self.Value,
+		Labels:  labels.Merge(customLabels(d.cfg.CustomLabels)),
+		Targets: []model.LabelSet{{model.AddressLabel: model.LabelValue(d.address(vm.Guest.IpAddress))}},
+	}
+}
+
+// hostSelected returns whether the host is in one of the configured datacenters and clusters
+func (d *VSphereDiscovery) hostSelected(inv vsphereInventory, id string) bool {
+	if len(d.cfg.Datacenters) > 0 && !vsphereContains(d.cfg.Datacenters, inv.ancestor(id, "Datacenter")) {
+		return false
+	}
+	return len(d.cfg.Clusters) == 0 || vsphereContains(d.cfg.Clusters, inv.ancestor(id, "ClusterComputeResource"))
+}
+
+func (d *VSphereDiscovery) powerStateSelected(state string) bool {
+	for _, s := range d.cfg.PowerStates {
+		if strings.EqualFold(s, state) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *VSphereDiscovery) address(host string) string {
+	if d.cfg.Port > 0 {
+		return net.JoinHostPort(host, strconv.Itoa(d.cfg.Port))
+	}
+	return host
+}
+
+// setLabels sets the datacenter and cluster of the host
+func (inv vsphereInventory) setLabels(labels model.LabelSet, id string) {
+	if dc := inv.ancestor(id, "Datacenter"); dc != "" {
+		labels[model.LabelName("datacenter")] = model.LabelValue(dc)
+	}
+	if c := inv.ancestor(id, "ClusterComputeResource"); c != "" {
+		labels[model.LabelName("cluster")] = model.LabelValue(c)
+	}
+}
+
+// ancestor returns the name of the first object of the type, starting with the object of the id and going up its parents
+func (inv vsphereInventory) ancestor(id, objectType string) string {
+	for e, ok := inv.entities[id]; ok; e, ok = inv.entities[parentID(e)] {
+		if e.Self.Type == objectType {
+			return e.Name
+		}
+	}
+	return ""
+}
+
+// inFolder returns whether the object of the id is one of the folders, or below one of them. Any object is if there are no folders.
+func (inv vsphereInventory) inFolder(id string, folders []string) bool {
+	if len(folders) == 0 {
+		return true
+	}
+	for e, ok := inv.entities[id]; ok; e, ok = inv.entities[parentID(e)] {
+		if e.Self.Type == "Folder" && vsphereContains(folders, e.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parentID returns the id of the parent of the entity, empty for the root folder
+func parentID(e mo.ManagedEntity) string {
+	if e.Parent == nil {
+		return ""
+	}
+	return e.Parent.Value
+}
+
+// found returns whether there is an object of the type with one of the names
+func (inv vsphereInventory) found(objectType string, names []string) bool {
+	for _, e := range inv.entities {
+		if e.Self.Type == objectType && vsphereContains(names, e.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func vsphereContains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+package discovery
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/sapcc/atlas/pkg/clients"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vapi/rest"
+	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+
+	// Serves the REST api of the tags next to the vim25 api
+	_ "github.com/vmware/govmomi/vapi/simulator"
+)
+
+// vcsim starts a simulated vCenter with one datacenter, a cluster of two hosts, a standalone host and two vms
+// per host. It returns a discovery client, and a logged in client with the login to set the simulation up.
+func vcsim(t *testing.T) (*clients.VSphereClient, *vim25.Client, *url.Userinfo) {
+	model := simulator.VPX()
+	model.ClusterHost = 2
+	model.Machine = 2
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(model.Remove)
+	model.Service.RegisterEndpoints = true
+	s := model.Service.NewServer()
+	t.Cleanup(s.Close)
+
+	password, _ := s.URL.User.Password()
+	client, err := clients.NewVSphereClient(s.URL.String(), s.URL.User.Username(), password, "", true, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vim, err := vim25.NewClient(context.Background(), soap.NewClient(s.URL, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = session.NewManager(vim).Login(context.Background(), s.URL.User); err != nil {
+		t.Fatal(err)
+	}
+	return client, vim, s.URL.User
+}
+
+func TestVSphereDiscovery(t *testing.T) {
+	ctx := context.Background()
+	client, _, _ := vcsim(t)
+
+	// The simulated vms run the VMware tools, but have no guest ip
+	var ips int
+	for _, obj := range simulator.Map.All("VirtualMachine") {
+		vm := obj.(*simulator.VirtualMachine)
+		if vm.Name == "DC0_C0_RP0_VM0" || vm.Name == "DC0_H0_VM0" {
+			vm.Guest.ToolsRunningStatus = string(types.VirtualMachineToolsRunningStatusGuestToolsRunning)
+			ips++
+			vm.Guest.IpAddress = "10.0.0." + string(rune('0'+ips))
+		}
+	}
+
+	tests := []struct {
+		name    string
+		cfg     vsphereConfig
+		sources []string
+		labels  map[string]model.LabelSet
+	}{
+		{
+			name:    "all hosts",
+			cfg:     vsphereConfig{Objects: []string{vsphereHosts}},
+			sources: []string{"host/host-21", "host/host-34", "host/host-42"},
+			labels: map[string]model.LabelSet{
+				"host/host-34": {"datacenter": "DC0", "cluster": "DC0_C0", "type": "esxi_host", "power_state": "powered_on", "connection_state": "connected"},
+				"host/host-21": {"datacenter": "DC0", "type": "esxi_host"},
+			},
+		},
+		{
+			name:    "cluster hosts",
+			cfg:     vsphereConfig{Objects: []string{vsphereHosts}, Clusters: []string{"DC0_C0"}, Port: 9100},
+			sources: []string{"host/host-34", "host/host-42"},
+		},
+		{
+			name:    "vms with guest ip",
+			cfg:     vsphereConfig{Objects: []string{vsphereVMs}},
+			sources: []string{"vm/vm-47", "vm/vm-53"},
+			labels: map[string]model.LabelSet{
+				"vm/vm-53": {"datacenter": "DC0", "cluster": "DC0_C0", "type": "vm", "datastore": "LocalDS_0", "power_state": "powered_on"},
+				"vm/vm-47": {"datacenter": "DC0", "type": "vm", "datastore": "LocalDS_0"},
+			},
+		},
+		{
+			name:    "vms of the cluster",
+			cfg:     vsphereConfig{Objects: []string{vsphereVMs}, Clusters: []string{"DC0_C0"}},
+			sources: []string{"vm/vm-53"},
+		},
+		{
+			name:    "vm folder",
+			cfg:     vsphereConfig{Objects: []string{vsphereVMs}, VMFolders: []string{"vm"}},
+			sources: []string{"vm/vm-47", "vm/vm-53"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.PowerStates = []string{"POWERED_ON"}
+			d := &VSphereDiscovery{cfg: tt.cfg, client: client, logger: log.NewNopLogger()}
+			groups, err := d.loadObjects(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkVSphereGroups(t, groups, tt.sources, tt.labels)
+		})
+	}
+
+	d := &VSphereDiscovery{cfg: vsphereConfig{Clusters: []string{"missing"}, Objects: []string{vsphereHosts}}, client: client, logger: log.NewNopLogger()}
+	if _, err := d.loadObjects(ctx); err == nil {
+		t.Errorf("expected an error for a missing cluster")
+	}
+}
+
+func TestVSphereDiscoveryTags(t *testing.T) {
+	ctx := context.Background()
+	client, vim, login := vcsim(t)
+
+	rc := rest.NewClient(vim)
+	if err := rc.Login(ctx, login); err != nil {
+		t.Fatal(err)
+	}
+	m := tags.NewManager(rc)
+	category, err := m.CreateCategory(ctx, &tags.Category{Name: "monitoring", Cardinality: "MULTIPLE", AssociableTypes: []string{"HostSystem"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	monitored, err := m.CreateTag(ctx, &tags.Tag{Name: "monitored", CategoryID: category})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prod, err := m.CreateTag(ctx, &tags.Tag{Name: "prod", CategoryID: category})
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := func(id string) mo.Reference {
+		return types.ManagedObjectReference{Type: "HostSystem", Value: id}
+	}
+	for _, a := range []struct {
+		tag  string
+		host string
+	}{{monitored, "host-21"}, {monitored, "host-34"}, {prod, "host-34"}, {prod, "host-42"}} {
+		if err = m.AttachTag(ctx, a.tag, host(a.host)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := &VSphereDiscovery{cfg: vsphereConfig{Objects: []string{vsphereHosts}, Tags: []string{"monitored", "prod"}, PowerStates: []string{"POWERED_ON"}}, client: client, logger: log.NewNopLogger()}
+	groups, err := d.loadObjects(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkVSphereGroups(t, groups, []string{"host/host-34"}, nil)
+
+	// The resolved tag ids are reused
+	if err = m.AttachTag(ctx, prod, host("host-21")); err != nil {
+		t.Fatal(err)
+	}
+	resolved := d.tagsResolved
+	if groups, err = d.loadObjects(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkVSphereGroups(t, groups, []string{"host/host-21", "host/host-34"}, nil)
+	if d.tagsResolved != resolved {
+		t.Errorf("tags resolved again")
+	}
+
+	d.cfg.Tags = []string{"missing"}
+	d.tagIDs = nil
+	if _, err = d.loadObjects(ctx); err == nil {
+		t.Errorf("expected an error for a missing tag")
+	}
+}
+
+func checkVSphereGroups(t *testing.T, groups []*targetgroup.Group, sources []string, labels map[string]model.LabelSet) {
+	t.Helper()
+	var got []string
+	for _, g := range groups {
+		got = append(got, g.Source)
+		for name, value := range labels[g.Source] {
+			if g.Labels[name] != value {
+				t.Errorf("%s: label %s = %q, want %q", g.Source, name, g.Labels[name], value)
+			}
+		}
+		if len(g.Targets) != 1 || g.Targets[0][model.AddressLabel] == "" {
+			t.Errorf("%s: invalid targets %v", g.Source, g.Targets)
+		}
+	}
+	sort.Strings(got)
+	if len(got) != len(sources) {
+		t.Fatalf("got groups %v, want %v", got, sources)
+	}
+	for i := range got {
+		if got[i] != sources[i] {
+			t.Fatalf("got groups %v, want %v", got, sources)
+		}
+	}
+}
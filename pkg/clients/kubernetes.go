@@ -0,0 +1,168 @@
+/**
+ * Copyright 2021 SAP SE
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clients
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// kubeconfig is the part of a kubeconfig file needed to connect to a cluster.
+// Auth providers and exec plugins aren't supported, they are only read to reject them.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			ClientCertificate     string      `yaml:"client-certificate"`
+			ClientCertificateData string      `yaml:"client-certificate-data"`
+			ClientKey             string      `yaml:"client-key"`
+			ClientKeyData         string      `yaml:"client-key-data"`
+			Token                 string      `yaml:"token"`
+			TokenFile             string      `yaml:"tokenFile"`
+			Username              string      `yaml:"username"`
+			Password              string      `yaml:"password"`
+			AuthProvider          interface{} `yaml:"auth-provider"`
+			Exec                  interface{} `yaml:"exec"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// NewKubernetesClient creates a clientset for the context of the kubeconfig file, the current context if empty.
+// Without a kubeconfig file the in-cluster config is used.
+func NewKubernetesClient(kubeconfigPath, context string) (*kubernetes.Clientset, error) {
+	var (
+		config *rest.Config
+		err    error
+	)
+	if kubeconfigPath == "" {
+		config, err = rest.InClusterConfig()
+	} else {
+		config, err = kubeconfigRestConfig(kubeconfigPath, context)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+func kubeconfigRestConfig(path, context string) (*rest.Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kc kubeconfig
+	if err = yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("Error parsing kubeconfig %s: %w", path, err)
+	}
+	if context == "" {
+		context = kc.CurrentContext
+	}
+	var clusterName, userName string
+	found := false
+	for _, c := range kc.Contexts {
+		if c.Name == context {
+			clusterName, userName, found = c.Context.Cluster, c.Context.User, true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("context %s not found in kubeconfig %s", context, path)
+	}
+
+	// Relative file names are relative to the kubeconfig
+	dir := filepath.Dir(path)
+	resolve := func(file string) string {
+		if file == "" || filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(dir, file)
+	}
+
+	config := &rest.Config{}
+	found = false
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		found = true
+		config.Host = c.Cluster.Server
+		config.Insecure = c.Cluster.InsecureSkipTLSVerify
+		config.CAFile = resolve(c.Cluster.CertificateAuthority)
+		if config.CAData, err = base64.StdEncoding.DecodeString(c.Cluster.CertificateAuthorityData); err != nil {
+			return nil, fmt.Errorf("Error decoding certificate-authority-data of cluster %s: %w", clusterName, err)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("cluster %s not found in kubeconfig %s", clusterName, path)
+	}
+	found = userName == ""
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		found = true
+		if u.User.AuthProvider != nil || u.User.Exec != nil {
+			return nil, fmt.Errorf("user %s of kubeconfig %s uses an auth provider or exec plugin, which is not supported", userName, path)
+		}
+		config.CertFile = resolve(u.User.ClientCertificate)
+		config.KeyFile = resolve(u.User.ClientKey)
+		if config.CertData, err = base64.StdEncoding.DecodeString(u.User.ClientCertificateData); err != nil {
+			return nil, fmt.Errorf("Error decoding client-certificate-data of user %s: %w", userName, err)
+		}
+		if config.KeyData, err = base64.StdEncoding.DecodeString(u.User.ClientKeyData); err != nil {
+			return nil, fmt.Errorf("Error decoding client-key-data of user %s: %w", userName, err)
+		}
+		config.BearerToken = u.User.Token
+		if u.User.TokenFile != "" {
+			token, err := ioutil.ReadFile(resolve(u.User.TokenFile))
+			if err != nil {
+				return nil, err
+			}
+			config.BearerToken = strings.TrimSpace(string(token))
+		}
+		config.Username = u.User.Username
+		config.Password = u.User.Password
+	}
+	if !found {
+		return nil, fmt.Errorf("user %s not found in kubeconfig %s", userName, path)
+	}
+	return config, nil
+}
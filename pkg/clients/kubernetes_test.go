@@ -0,0 +1,97 @@
+package clients
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testKubeconfig = `
+current-context: main
+clusters:
+  - name: main
+    cluster:
+      server: https://main.example.com
+      certificate-authority: ca.pem
+  - name: other
+    cluster:
+      server: https://other.example.com
+      insecure-skip-tls-verify: true
+users:
+  - name: admin
+    user:
+      token: secret
+  - name: oidc
+    user:
+      auth-provider:
+        name: oidc
+  - name: plugin
+    user:
+      exec:
+        command: get-token
+contexts:
+  - name: main
+    context:
+      cluster: main
+      user: admin
+  - name: anonymous
+    context:
+      cluster: other
+  - name: unknown-user
+    context:
+      cluster: main
+      user: nobody
+  - name: unknown-cluster
+    context:
+      cluster: nowhere
+      user: admin
+  - name: auth-provider
+    context:
+      cluster: main
+      user: oidc
+  - name: exec
+    context:
+      cluster: main
+      user: plugin
+`
+
+func TestKubeconfigRestConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kubeconfig")
+	if err := ioutil.WriteFile(path, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		context string
+		host    string
+		token   string
+		caFile  string
+		err     string
+	}{
+		{context: "", host: "https://main.example.com", token: "secret", caFile: filepath.Join(dir, "ca.pem")},
+		{context: "anonymous", host: "https://other.example.com"},
+		{context: "missing", err: "context missing not found"},
+		{context: "unknown-user", err: "user nobody not found"},
+		{context: "unknown-cluster", err: "cluster nowhere not found"},
+		{context: "auth-provider", err: "not supported"},
+		{context: "exec", err: "not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.context, func(t *testing.T) {
+			config, err := kubeconfigRestConfig(path, tt.context)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Host != tt.host || config.BearerToken != tt.token || config.CAFile != tt.caFile {
+				t.Errorf("got host %s, token %q, ca file %q", config.Host, config.BearerToken, config.CAFile)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+/**
+ * Copyright 2021 SAP SE
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clients
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vapi/rest"
+	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+)
+
+// VSphereClient reads the inventory of a vCenter, or a vcsim simulator, with the vim25 api (/sdk).
+// The tags are read with the vSphere Automation REST api (/rest) of vCenter 6.5 or newer.
+// Sessions are created with the first request, and again once they expired.
+type VSphereClient struct {
+	soap *soap.Client
+	user *url.Userinfo
+
+	mu   sync.Mutex
+	vim  *vim25.Client
+	rest *rest.Client
+}
+
+// NewVSphereClient creates a client for the vCenter, e.g. https://vcenter.example.com. caCert is the path of a
+// PEM encoded CA bundle, the system CAs are used if empty.
+func NewVSphereClient(vcenterURL, user, password, caCert string, insecureSkipVerify bool, timeout time.Duration) (*VSphereClient, error) {
+	u, err := soap.ParseURL(vcenterURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vsphere url %s: %w", vcenterURL, err)
+	}
+	u.User = nil
+	sc := soap.NewClient(u, insecureSkipVerify)
+	if caCert != "" {
+		if err = sc.SetRootCAs(caCert); err != nil {
+			return nil, fmt.Errorf("invalid vsphere ca_cert: %w", err)
+		}
+	}
+	sc.Timeout = timeout
+	return &VSphereClient{soap: sc, user: url.UserPassword(user, password)}, nil
+}
+
+// Retrieve loads the properties of all objects of the kinds (e.g. HostSystem) in the inventory into dst,
+// a pointer to a slice of mo types (e.g. *[]mo.HostSystem).
+func (c *VSphereClient) Retrieve(ctx context.Context, kinds, properties []string, dst interface{}) error {
+	vim, err := c.vimClient(ctx)
+	if err != nil {
+		return err
+	}
+	v, err := view.NewManager(vim).CreateContainerView(ctx, vim.ServiceContent.RootFolder, kinds, true)
+	if err != nil {
+		return err
+	}
+	defer v.Destroy(ctx)
+	return v.Retrieve(ctx, kinds, properties, dst)
+}
+
+// TagIDs returns the ids of the tags by their names. Tag names are only unique within a category, so a name can
+// stand for several tags. Every tag is read with its own request, so the ids should be reused.
+func (c *VSphereClient) TagIDs(ctx context.Context, names []string) (map[string][]string, error) {
+	m, err := c.tagManager(ctx)
+	if err != nil {
+		return nil, err
+	}
+	all, err := m.GetTags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string][]string, len(names))
+	for _, name := range names {
+		for _, t := range all {
+			if t.Name == name {
+				ids[name] = append(ids[name], t.ID)
+			}
+		}
+		if len(ids[name]) == 0 {
+			return nil, fmt.Errorf("vsphere tag %s not found", name)
+		}
+	}
+	return ids, nil
+}
+
+// TaggedObjects returns the ids of the objects (e.g. vm-42) the tags are attached to, by tag id, with one request
+func (c *VSphereClient) TaggedObjects(ctx context.Context, tagIDs []string) (map[string][]string, error) {
+	m, err := c.tagManager(ctx)
+	if err != nil {
+		return nil, err
+	}
+	attached, err := m.ListAttachedObjectsOnTags(ctx, tagIDs)
+	if err != nil {
+		return nil, err
+	}
+	objects := make(map[string][]string, len(attached))
+	for _, a := range attached {
+		for _, o := range a.ObjectIDs {
+			objects[a.TagID] = append(objects[a.TagID], o.Reference().Value)
+		}
+	}
+	return objects, nil
+}
+
+// vimClient returns the vim25 client, logging in if there is no valid session
+func (c *VSphereClient) vimClient(ctx context.Context) (*vim25.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.vim == nil {
+		vim, err := vim25.NewClient(ctx, c.soap)
+		if err != nil {
+			return nil, fmt.Errorf("Error connecting to vsphere: %w", err)
+		}
+		c.vim = vim
+	}
+	m := session.NewManager(c.vim)
+	s, err := m.UserSession(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error checking vsphere session: %w", err)
+	}
+	if s == nil {
+		if err = m.Login(ctx, c.user); err != nil {
+			return nil, fmt.Errorf("Error creating vsphere session: %w", err)
+		}
+	}
+	return c.vim, nil
+}
+
+// tagManager returns a tag manager of the REST api, logging in if there is no valid session
+func (c *VSphereClient) tagManager(ctx context.Context) (*tags.Manager, error) {
+	vim, err := c.vimClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rest == nil {
+		c.rest = rest.NewClient(vim)
+	}
+	s, err := c.rest.Session(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error checking vsphere rest session: %w", err)
+	}
+	if s == nil {
+		if err = c.rest.Login(ctx, c.user); err != nil {
+			return nil, fmt.Errorf("Error creating vsphere rest session: %w", err)
+		}
+	}
+	return tags.NewManager(c.rest), nil
+}
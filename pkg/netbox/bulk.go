@@ -0,0 +1,240 @@
+/**
+ * Copyright 2020 SAP SE
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/netbox-community/go-netbox/netbox/client/dcim"
+	"github.com/netbox-community/go-netbox/netbox/models"
+)
+
+// bulkSize is the max number of ids sent in one multi-value filter, to keep the urls short
+const bulkSize = 100
+
+// BulkManagementIPs retrieves the IPs of all management interfaces of the devices, keyed by device id.
+// Instead of two requests per device, interfaces and IPs are fetched for up to bulkSize devices at once.
+func (nb *Netbox) BulkManagementIPs(deviceIDs []int64) (map[int64][]DeviceIP, error) {
+	return nb.bulkInterfaceIPs(deviceIDs, url.Values{"mgmt_only": {"true"}})
+}
+
+// BulkInterfaceNameIPs retrieves the IPs of the named interface of the devices, keyed by device id
+func (nb *Netbox) BulkInterfaceNameIPs(name string, deviceIDs []int64) (map[int64][]DeviceIP, error) {
+	return nb.bulkInterfaceIPs(deviceIDs, url.Values{"name": {name}})
+}
+
+// bulkInterfaceIPs fetches the ips of the devices' interfaces matching the filter. Devices found in the cache are
+// not fetched again, the fetched ones are cached per device, including the ones without ips.
+func (nb *Netbox) bulkInterfaceIPs(deviceIDs []int64, filter url.Values) (ips map[int64][]DeviceIP, err error) {
+	ips = make(map[int64][]DeviceIP, len(deviceIDs))
+	intfs := make(map[int64]models.Interface)
+	intfIDs := make([]int64, 0)
+	missing := make([]int64, 0, len(deviceIDs))
+	cacheKey := func(id int64) string {
+		return fmt.Sprintf("%d/bulk/%s", id, filter.Encode())
+	}
+	for _, id := range deviceIDs {
+		if v, ok := nb.cacheGet(cacheIPAddresses, cacheKey(id)); ok {
+			if deviceIPs := v.([]DeviceIP); len(deviceIPs) > 0 {
+				ips[id] = deviceIPs
+			}
+			continue
+		}
+		missing = append(missing, id)
+	}
+
+	for _, chunk := range chunkIDs(missing) {
+		query := url.Values{"device_id": chunk}
+		for k, v := range filter {
+			query[k] = v
+		}
+		err = nb.List("/dcim/interfaces/", nil, query, func(r json.RawMessage) error {
+			var intf models.Interface
+			if err := decode(r, &intf); err != nil {
+				return err
+			}
+			if intf.Device == nil {
+				return nil
+			}
+			intfs[intf.ID] = intf
+			intfIDs = append(intfIDs, intf.ID)
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	for _, chunk := range chunkIDs(intfIDs) {
+		err = nb.List("/ipam/ip-addresses/", nil, url.Values{"interface_id": chunk}, func(r json.RawMessage) error {
+			var addr models.IPAddress
+			if err := decode(r, &addr); err != nil {
+				return err
+			}
+			if addr.Address == nil || addr.AssignedObjectID == nil || addr.AssignedObjectType != "dcim.interface" {
+				return nil
+			}
+			intf, ok := intfs[*addr.AssignedObjectID]
+			if !ok {
+				return nil
+			}
+			ip, err := NewDeviceIP(*addr.Address, interfaceName(&intf))
+			if err != nil {
+				return err
+			}
+			ips[intf.Device.ID] = append(ips[intf.Device.ID], ip)
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	for _, id := range missing {
+		nb.cacheSet(cacheIPAddresses, cacheKey(id), ips[id])
+	}
+	return
+}
+
+// BulkInterfacesByParams retrieves the interfaces of the devices matching the dcim list params, keyed by device id.
+// They are always listed raw, as the generated client drops their custom fields.
+func (nb *Netbox) BulkInterfacesByParams(params dcim.DcimInterfacesListParams, rawQuery RawQuery, deviceIDs []int64) (res map[int64][]Interface, err error) {
+	res = make(map[int64][]Interface, len(deviceIDs))
+	params.WithTimeout(nb.timeout)
+	params.WithContext(nb.ctx)
+	params.DeviceID = nil
+	for _, chunk := range chunkIDs(deviceIDs) {
+		query := rawQuery.Values()
+		query["device_id"] = chunk
+		err = nb.List("/dcim/interfaces/", &params, query, func(r json.RawMessage) error {
+			var intf Interface
+			if err := decode(r, &intf); err != nil {
+				return err
+			}
+			if intf.Device != nil {
+				res[intf.Device.ID] = append(res[intf.Device.ID], intf)
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// BulkPowerPortFeedIDs retrieves the ids of the power feeds connected to the power ports of the devices, keyed by device id
+func (nb *Netbox) BulkPowerPortFeedIDs(deviceIDs []int64) (ids map[int64][]int64, err error) {
+	ids = make(map[int64][]int64, len(deviceIDs))
+	for _, chunk := range chunkIDs(deviceIDs) {
+		err = nb.List("/dcim/power-ports/", nil, url.Values{"device_id": chunk}, func(r json.RawMessage) error {
+			var port struct {
+				Device *struct {
+					ID int64 `json:"id"`
+				} `json:"device"`
+				ConnectedEndpointType string `json:"connected_endpoint_type"`
+				ConnectedEndpoint     *struct {
+					ID int64 `json:"id"`
+				} `json:"connected_endpoint"`
+			}
+			if err := decode(r, &port); err != nil {
+				return err
+			}
+			if port.Device != nil && port.ConnectedEndpointType == "dcim.powerfeed" && port.ConnectedEndpoint != nil {
+				ids[port.Device.ID] = append(ids[port.Device.ID], port.ConnectedEndpoint.ID)
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// BulkPowerOutletDevices retrieves the devices connected to the power outlets of the devices, e.g. the servers of pdus,
+// keyed by the id of the device with the outlets
+func (nb *Netbox) BulkPowerOutletDevices(deviceIDs []int64) (res map[int64][]models.DeviceWithConfigContext, err error) {
+	res = make(map[int64][]models.DeviceWithConfigContext, len(deviceIDs))
+	// outlets holds the ids of the devices with outlets by the id of the connected device
+	outlets := make(map[int64][]int64)
+	connectedIDs := make([]int64, 0)
+	for _, chunk := range chunkIDs(deviceIDs) {
+		err = nb.List("/dcim/power-outlets/", nil, url.Values{"device_id": chunk}, func(r json.RawMessage) error {
+			var outlet struct {
+				Device *struct {
+					ID int64 `json:"id"`
+				} `json:"device"`
+				ConnectedEndpointType string `json:"connected_endpoint_type"`
+				ConnectedEndpoint     *struct {
+					Device *struct {
+						ID int64 `json:"id"`
+					} `json:"device"`
+				} `json:"connected_endpoint"`
+			}
+			if err := decode(r, &outlet); err != nil {
+				return err
+			}
+			if outlet.Device == nil || outlet.ConnectedEndpointType != "dcim.powerport" || outlet.ConnectedEndpoint == nil || outlet.ConnectedEndpoint.Device == nil {
+				return nil
+			}
+			id := outlet.ConnectedEndpoint.Device.ID
+			if _, ok := outlets[id]; !ok {
+				connectedIDs = append(connectedIDs, id)
+			}
+			outlets[id] = append(outlets[id], outlet.Device.ID)
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	for _, chunk := range chunkIDs(connectedIDs) {
+		err = nb.List("/dcim/devices/", nil, url.Values{"id": chunk}, func(r json.RawMessage) error {
+			var device models.DeviceWithConfigContext
+			if err := decode(r, &device); err != nil {
+				return err
+			}
+			for _, id := range outlets[device.ID] {
+				res[id] = append(res[id], device)
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func chunkIDs(ids []int64) (chunks [][]string) {
+	for i := 0; i < len(ids); i += bulkSize {
+		end := i + bulkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		chunk := make([]string, 0, end-i)
+		for _, id := range ids[i:end] {
+			chunk = append(chunk, strconv.FormatInt(id, 10))
+		}
+		chunks = append(chunks, chunk)
+	}
+	return
+}
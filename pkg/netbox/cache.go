@@ -0,0 +1,213 @@
+/**
+ * Copyright 2020 SAP SE
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netbox
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	cacheInterfaces  = "interfaces"
+	cacheIPAddresses = "ip_addresses"
+	cacheSites       = "sites"
+	cacheDeviceTypes = "device_types"
+	cacheRegions     = "regions"
+	cacheTenants     = "tenants"
+	cacheClusters    = "clusters"
+)
+
+// CacheTTL holds the seconds a cached lookup is reused, per kind of object. 0 disables the cache for the kind.
+type CacheTTL struct {
+	Interfaces  int `yaml:"interfaces"`
+	IPAddresses int `yaml:"ip_addresses"`
+	Sites       int `yaml:"sites"`
+	DeviceTypes int `yaml:"device_types"`
+	Regions     int `yaml:"regions"`
+	Tenants     int `yaml:"tenants"`
+	Clusters    int `yaml:"clusters"`
+}
+
+type (
+	cache struct {
+		sync.Mutex
+		entries   map[string]cacheEntry
+		maxAge    time.Duration
+		lastPrune time.Time
+	}
+
+	cacheEntry struct {
+		value   interface{}
+		fetched time.Time
+	}
+)
+
+var (
+	cachesMu sync.Mutex
+	// caches are shared by all Netbox instances of the same host and token, so that discoveries looking up the same
+	// devices don't hit netbox twice. Tokens can see different objects, so they don't share a cache.
+	caches = make(map[string]*cache)
+
+	cacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "atlas_netbox_cache_hits_total",
+		Help: "Number of netbox lookups answered from the cache",
+	}, []string{"kind"})
+	cacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "atlas_netbox_cache_misses_total",
+		Help: "Number of netbox lookups not found in the cache",
+	}, []string{"kind"})
+)
+
+func init() {
+	prometheus.MustRegister(cacheHits, cacheMisses)
+}
+
+func sharedCache(host, token string) *cache {
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+	key := host + "\x00" + token
+	c, ok := caches[key]
+	if !ok {
+		c = &cache{entries: make(map[string]cacheEntry), lastPrune: time.Now()}
+		caches[key] = c
+	}
+	return c
+}
+
+func (c *cache) get(key string, ttl time.Duration) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Since(e.fetched) > ttl {
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *cache) set(key string, value interface{}, ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	c.entries[key] = cacheEntry{value: value, fetched: now}
+	if ttl > c.maxAge {
+		c.maxAge = ttl
+	}
+	// Entries nobody asks for anymore would stay forever, so drop everything older than the longest ttl once in a while
+	if now.Sub(c.lastPrune) > c.maxAge {
+		for k, e := range c.entries {
+			if now.Sub(e.fetched) > c.maxAge {
+				delete(c.entries, k)
+			}
+		}
+		c.lastPrune = now
+	}
+}
+
+// deletePrefix drops all entries whose key starts with one of the prefixes
+func (c *cache) deletePrefix(prefixes ...string) {
+	c.Lock()
+	defer c.Unlock()
+	for k := range c.entries {
+		for _, p := range prefixes {
+			if strings.HasPrefix(k, p) {
+				delete(c.entries, k)
+				break
+			}
+		}
+	}
+}
+
+// SetCacheTTL enables the cache for the lookups of interfaces, ip addresses, sites, device types, regions, tenants and clusters
+func (nb *Netbox) SetCacheTTL(ttl CacheTTL) {
+	nb.cacheTTL = ttl
+}
+
+func (nb *Netbox) ttl(kind string) time.Duration {
+	var s int
+	switch kind {
+	case cacheInterfaces:
+		s = nb.cacheTTL.Interfaces
+	case cacheIPAddresses:
+		s = nb.cacheTTL.IPAddresses
+	case cacheSites:
+		s = nb.cacheTTL.Sites
+	case cacheDeviceTypes:
+		s = nb.cacheTTL.DeviceTypes
+	case cacheRegions:
+		s = nb.cacheTTL.Regions
+	case cacheTenants:
+		s = nb.cacheTTL.Tenants
+	case cacheClusters:
+		s = nb.cacheTTL.Clusters
+	}
+	return time.Duration(s) * time.Second
+}
+
+// cached returns the cached value of the key, or loads and caches it. Errors are not cached.
+func (nb *Netbox) cached(kind, key string, load func() (interface{}, error)) (interface{}, error) {
+	if v, ok := nb.cacheGet(kind, key); ok {
+		return v, nil
+	}
+	v, err := load()
+	if err != nil {
+		return nil, err
+	}
+	nb.cacheSet(kind, key, v)
+	return v, nil
+}
+
+// cacheGet returns the cached value of the key, if the cache is enabled for the kind
+func (nb *Netbox) cacheGet(kind, key string) (interface{}, bool) {
+	ttl := nb.ttl(kind)
+	if ttl <= 0 || nb.cache == nil {
+		return nil, false
+	}
+	if v, ok := nb.cache.get(kind+"/"+key, ttl); ok {
+		cacheHits.WithLabelValues(kind).Inc()
+		return v, true
+	}
+	cacheMisses.WithLabelValues(kind).Inc()
+	return nil, false
+}
+
+// cacheSet caches the value of the key, if the cache is enabled for the kind
+func (nb *Netbox) cacheSet(kind, key string, v interface{}) {
+	ttl := nb.ttl(kind)
+	if ttl <= 0 || nb.cache == nil {
+		return
+	}
+	nb.cache.set(kind+"/"+key, v, ttl)
+}
+
+// InvalidateDevices drops the cached interfaces and ip addresses of the devices, so that changed devices are
+// reloaded from netbox instead of being served stale until the ttl expires. Their keys start with the device id.
+// VM interfaces and ips aren't cached.
+func (nb *Netbox) InvalidateDevices(ids []int64) {
+	if nb.cache == nil || len(ids) == 0 {
+		return
+	}
+	prefixes := make([]string, 0, 2*len(ids))
+	for _, id := range ids {
+		device := strconv.FormatInt(id, 10) + "/"
+		prefixes = append(prefixes, cacheInterfaces+"/"+device, cacheIPAddresses+"/"+device)
+	}
+	nb.cache.deletePrefix(prefixes...)
+}
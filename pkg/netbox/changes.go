@@ -0,0 +1,141 @@
+/**
+ * Copyright 2020 SAP SE
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netbox
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strconv"
+)
+
+// ObjectChange is an entry of the netbox change log.
+// Netbox up to 2.10 stores the serialized object in object_data, newer versions in pre- and postchange_data.
+type ObjectChange struct {
+	ID                int64                  `json:"id"`
+	ChangedObjectType string                 `json:"changed_object_type"`
+	ChangedObjectID   int64                  `json:"changed_object_id"`
+	ObjectData        map[string]interface{} `json:"object_data"`
+	PrechangeData     map[string]interface{} `json:"prechange_data"`
+	PostchangeData    map[string]interface{} `json:"postchange_data"`
+}
+
+// RelatedIDs returns the ids the serialized object holds in field (e.g. "device"), before and after the change.
+// The field may hold the plain id or a nested object, as in webhook payloads.
+func (c ObjectChange) RelatedIDs(field string) (ids []int64) {
+	for _, data := range []map[string]interface{}{c.ObjectData, c.PrechangeData, c.PostchangeData} {
+		value := data[field]
+		if nested, ok := value.(map[string]interface{}); ok {
+			value = nested["id"]
+		}
+		// json numbers are decoded as float64
+		if id, ok := value.(float64); ok {
+			ids = append(ids, int64(id))
+		}
+	}
+	return
+}
+
+// OnlyCustomFields reports whether the change touched nothing but the custom fields (and the last_updated timestamp),
+// e.g. the fields written back by atlas itself. Changes without pre- and postchange data (netbox up to 2.10) never do.
+func (c ObjectChange) OnlyCustomFields(fields ...string) bool {
+	if len(fields) == 0 || c.PrechangeData == nil || c.PostchangeData == nil {
+		return false
+	}
+	return reflect.DeepEqual(withoutFields(c.PrechangeData, fields), withoutFields(c.PostchangeData, fields))
+}
+
+// withoutFields returns a copy of the serialized object without last_updated and the custom fields
+func withoutFields(data map[string]interface{}, fields []string) map[string]interface{} {
+	stripped := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		if k != "last_updated" {
+			stripped[k] = v
+		}
+	}
+	if cfs, ok := data["custom_fields"].(map[string]interface{}); ok {
+		left := make(map[string]interface{}, len(cfs))
+		for k, v := range cfs {
+			left[k] = v
+		}
+		for _, f := range fields {
+			delete(left, f)
+		}
+		stripped["custom_fields"] = left
+	}
+	return stripped
+}
+
+// LastObjectChangeID retrieves the id of the latest change log entry, 0 if the log is empty
+func (nb *Netbox) LastObjectChangeID() (id int64, err error) {
+	page, err := nb.listPage("/extras/object-changes/", nil, url.Values{"ordering": {"-id"}}, 1, 0)
+	if err != nil || len(page.Results) == 0 {
+		return
+	}
+	var c ObjectChange
+	if err = json.Unmarshal(page.Results[0], &c); err != nil {
+		return
+	}
+	return c.ID, nil
+}
+
+// ObjectChanges retrieves all change log entries newer than the entry with the id
+func (nb *Netbox) ObjectChanges(afterID int64) (changes []ObjectChange, err error) {
+	changes = make([]ObjectChange, 0)
+	query := url.Values{"id__gt": {strconv.FormatInt(afterID, 10)}}
+	err = nb.List("/extras/object-changes/", nil, query, func(r json.RawMessage) error {
+		var c ObjectChange
+		if err := json.Unmarshal(r, &c); err != nil {
+			return err
+		}
+		changes = append(changes, c)
+		return nil
+	})
+	return
+}
+
+// InterfaceOwners retrieves the devices and vms that have an interface with the id.
+// Both are asked, as the change log of an ip address doesn't always tell which kind of interface it is assigned to.
+func (nb *Netbox) InterfaceOwners(intfID int64) (deviceIDs, vmIDs []int64, err error) {
+	query := url.Values{"id": {strconv.FormatInt(intfID, 10)}}
+	for _, path := range []string{"/dcim/interfaces/", "/virtualization/interfaces/"} {
+		err = nb.List(path, nil, query, func(r json.RawMessage) error {
+			var owner struct {
+				Device *struct {
+					ID int64 `json:"id"`
+				} `json:"device"`
+				VirtualMachine *struct {
+					ID int64 `json:"id"`
+				} `json:"virtual_machine"`
+			}
+			if err := json.Unmarshal(r, &owner); err != nil {
+				return err
+			}
+			if owner.Device != nil {
+				deviceIDs = append(deviceIDs, owner.Device.ID)
+			}
+			if owner.VirtualMachine != nil {
+				vmIDs = append(vmIDs, owner.VirtualMachine.ID)
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
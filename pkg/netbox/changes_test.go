@@ -0,0 +1,81 @@
+package netbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOnlyCustomFields(t *testing.T) {
+	device := func(status string, fields map[string]interface{}, updated string) map[string]interface{} {
+		return map[string]interface{}{
+			"id":            float64(1),
+			"status":        status,
+			"custom_fields": fields,
+			"last_updated":  updated,
+		}
+	}
+	tests := []struct {
+		name   string
+		change ObjectChange
+		fields []string
+		want   bool
+	}{
+		{
+			name: "write back fields",
+			change: ObjectChange{
+				PrechangeData:  device("active", map[string]interface{}{"monitored": nil, "seen": nil, "owner": "x"}, "t1"),
+				PostchangeData: device("active", map[string]interface{}{"monitored": "snmp", "seen": "now", "owner": "x"}, "t2"),
+			},
+			fields: []string{"monitored", "seen"},
+			want:   true,
+		},
+		{
+			name: "other custom field",
+			change: ObjectChange{
+				PrechangeData:  device("active", map[string]interface{}{"monitored": nil, "owner": "x"}, "t1"),
+				PostchangeData: device("active", map[string]interface{}{"monitored": "snmp", "owner": "y"}, "t2"),
+			},
+			fields: []string{"monitored"},
+		},
+		{
+			name: "other field",
+			change: ObjectChange{
+				PrechangeData:  device("active", map[string]interface{}{"monitored": nil}, "t1"),
+				PostchangeData: device("offline", map[string]interface{}{"monitored": "snmp"}, "t2"),
+			},
+			fields: []string{"monitored"},
+		},
+		{
+			name:   "created",
+			change: ObjectChange{PostchangeData: device("active", map[string]interface{}{"monitored": nil}, "t1")},
+			fields: []string{"monitored"},
+		},
+		{
+			name: "no write back fields",
+			change: ObjectChange{
+				PrechangeData:  device("active", nil, "t1"),
+				PostchangeData: device("active", nil, "t2"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.change.OnlyCustomFields(tt.fields...); got != tt.want {
+				t.Errorf("OnlyCustomFields() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelatedIDs(t *testing.T) {
+	c := ObjectChange{
+		PrechangeData:  map[string]interface{}{"device": float64(1)},
+		PostchangeData: map[string]interface{}{"device": map[string]interface{}{"id": float64(2), "name": "node002"}},
+	}
+	if got, want := c.RelatedIDs("device"), []int64{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RelatedIDs() = %v, want %v", got, want)
+	}
+	if got := c.RelatedIDs("virtual_machine"); got != nil {
+		t.Errorf("RelatedIDs() = %v, want none", got)
+	}
+}
@@ -0,0 +1,131 @@
+/**
+ * Copyright 2020 SAP SE
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netbox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	runtimeclient "github.com/go-openapi/runtime/client"
+	netboxclient "github.com/netbox-community/go-netbox/netbox/client"
+)
+
+// defaultTimeout of a single netbox request in seconds
+const defaultTimeout = 30
+
+// ClientConfig configures how atlas connects to netbox. The zero value connects via https,
+// verifying the server certificate against the system CAs.
+type ClientConfig struct {
+	// Scheme is http or https (default)
+	Scheme string `yaml:"scheme"`
+	// BasePath of the rest api, defaults to /api
+	BasePath string `yaml:"base_path"`
+	// CACert is a PEM file with the CAs to verify the server certificate
+	CACert string `yaml:"ca_cert"`
+	// ClientCert and ClientKey are PEM files for authenticating with a client certificate
+	ClientCert string `yaml:"client_cert"`
+	ClientKey  string `yaml:"client_key"`
+	// ServerName overrides the name the server certificate is verified against
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	// Proxy url, e.g. http://proxy:3128. Without it the HTTPS_PROXY environment variables are used
+	Proxy string `yaml:"proxy"`
+	// Timeout of a single request in seconds, defaults to 30
+	Timeout int         `yaml:"timeout"`
+	Retry   RetryConfig `yaml:"retry"`
+}
+
+func (cfg ClientConfig) scheme() string {
+	if cfg.Scheme == "" {
+		return "https"
+	}
+	return cfg.Scheme
+}
+
+func (cfg ClientConfig) basePath() string {
+	if cfg.BasePath == "" {
+		return netboxclient.DefaultBasePath
+	}
+	return cfg.BasePath
+}
+
+// graphqlPath is the base path of the graphql api, which lives next to the rest api
+func (cfg ClientConfig) graphqlPath() string {
+	return path.Dir(strings.TrimSuffix(cfg.basePath(), "/"))
+}
+
+func (cfg ClientConfig) timeout() time.Duration {
+	if cfg.Timeout <= 0 {
+		return defaultTimeout * time.Second
+	}
+	return time.Duration(cfg.Timeout) * time.Second
+}
+
+// WithContext returns a copy of the instance which makes all requests with the context,
+// so that they are aborted once it is cancelled
+func (nb *Netbox) WithContext(ctx context.Context) *Netbox {
+	n := *nb
+	n.ctx = ctx
+	return &n
+}
+
+func transport(host, basePath, token string, cfg ClientConfig) (runtime.ClientTransport, error) {
+	scheme := cfg.scheme()
+	if scheme != "http" && scheme != "https" {
+		return nil, fmt.Errorf("invalid netbox scheme %s", scheme)
+	}
+	tlsConfig, err := runtimeclient.TLSClientAuth(runtimeclient.TLSClientOptions{
+		Certificate:        cfg.ClientCert,
+		Key:                cfg.ClientKey,
+		CA:                 cfg.CACert,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid netbox tls config: %w", err)
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.Proxy != "" {
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid netbox proxy %s: %w", cfg.Proxy, err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			Proxy:               proxy,
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: 10 * time.Second,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+
+	transport := runtimeclient.NewWithClient(host, basePath, []string{scheme}, httpClient)
+	if token != "" {
+		transport.DefaultAuthentication = runtimeclient.APIKeyAuth("Authorization", "header", fmt.Sprintf("Token %v", token))
+	}
+	return retryTransport{ClientTransport: transport, cfg: cfg.Retry}, nil
+}
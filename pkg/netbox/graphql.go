@@ -0,0 +1,156 @@
+/**
+ * Copyright 2020 SAP SE
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/go-openapi/runtime"
+	"github.com/go-openapi/strfmt"
+)
+
+type (
+	graphqlRequest struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables,omitempty"`
+	}
+
+	graphqlResponse struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+)
+
+// GraphQL runs the query against the graphql api of netbox (3.0+) and returns its data
+func (nb *Netbox) GraphQL(query string, variables map[string]interface{}) (map[string]interface{}, error) {
+	v, err := nb.Version()
+	if err != nil {
+		return nil, err
+	}
+	if !v.AtLeast(3, 0) {
+		return nil, fmt.Errorf("graphql needs netbox 3.0 or newer, the server runs %s", v)
+	}
+	vars, _ := jsonValue(variables).(map[string]interface{})
+	res, err := nb.graphql.Submit(&runtime.ClientOperation{
+		ID:                 "graphql",
+		Method:             "POST",
+		PathPattern:        "/graphql/",
+		ProducesMediaTypes: []string{"application/json"},
+		ConsumesMediaTypes: []string{"application/json"},
+		Params: runtime.ClientRequestWriterFunc(func(r runtime.ClientRequest, reg strfmt.Registry) error {
+			if err := r.SetTimeout(nb.timeout); err != nil {
+				return err
+			}
+			return r.SetBodyParam(graphqlRequest{Query: query, Variables: vars})
+		}),
+		Reader: runtime.ClientResponseReaderFunc(func(response runtime.ClientResponse, consumer runtime.Consumer) (interface{}, error) {
+			if response.Code() != 200 {
+				return nil, runtime.NewAPIError("unknown error", response, response.Code())
+			}
+			r := new(graphqlResponse)
+			if err := consumer.Consume(response.Body(), r); err != nil {
+				return nil, err
+			}
+			return r, nil
+		}),
+		Context: nb.ctx,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r := res.(*graphqlResponse)
+	if len(r.Errors) > 0 {
+		msgs := make([]string, 0, len(r.Errors))
+		for _, e := range r.Errors {
+			msgs = append(msgs, e.Message)
+		}
+		return nil, fmt.Errorf("graphql query failed: %s", strings.Join(msgs, "; "))
+	}
+	return r.Data, nil
+}
+
+// jsonValue converts the maps yaml decodes nested objects into (map[interface{}]interface{}), which can't be json
+// encoded, into map[string]interface{}. Lists are converted element by element, other values are returned as they are.
+func jsonValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, e := range value {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, e := range value {
+			m[k] = jsonValue(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(value))
+		for i, e := range value {
+			l[i] = jsonValue(e)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// FieldValues returns the values of the dotted path (e.g. "interfaces.ip_addresses.address") in a graphql result.
+// Lists on the way (and at the end) are walked through, so the path can yield several values.
+func FieldValues(data interface{}, path string) (values []interface{}) {
+	switch d := data.(type) {
+	case []interface{}:
+		for _, item := range d {
+			values = append(values, FieldValues(item, path)...)
+		}
+	case map[string]interface{}:
+		if path == "" {
+			return []interface{}{d}
+		}
+		parts := strings.SplitN(path, ".", 2)
+		rest := ""
+		if len(parts) == 2 {
+			rest = parts[1]
+		}
+		if v, ok := d[parts[0]]; ok && v != nil {
+			values = FieldValues(v, rest)
+		}
+	default:
+		if path == "" && d != nil {
+			values = []interface{}{d}
+		}
+	}
+	return
+}
+
+// FieldString formats a graphql result value as string. Objects and lists are json encoded.
+func FieldString(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case float64, bool:
+		return fmt.Sprint(value)
+	default:
+		b, _ := json.Marshal(value)
+		return string(b)
+	}
+}
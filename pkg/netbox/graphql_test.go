@@ -0,0 +1,84 @@
+package netbox
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestJSONValue(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		json string
+	}{
+		{"flat", "site: de1\nlimit: 10", `{"limit":10,"site":"de1"}`},
+		{"nested", "filters:\n  site: de1\n  role:\n    slug: server", `{"filters":{"role":{"slug":"server"},"site":"de1"}}`},
+		{"list of objects", "filters:\n  - tag: a\n  - tag: b", `{"filters":[{"tag":"a"},{"tag":"b"}]}`},
+		{"empty", "", `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var vars map[string]interface{}
+			if err := yaml.Unmarshal([]byte(tt.yaml), &vars); err != nil {
+				t.Fatal(err)
+			}
+			var v interface{}
+			if vars != nil {
+				v = jsonValue(vars)
+			}
+			b, err := json.Marshal(v)
+			if err != nil {
+				t.Fatalf("json.Marshal: %s", err)
+			}
+			if string(b) != tt.json {
+				t.Errorf("got %s, want %s", b, tt.json)
+			}
+		})
+	}
+}
+
+func TestGraphQLVersion(t *testing.T) {
+	nb := &Netbox{version: &versionState{version: &Version{Major: 2, Minor: 11}}}
+	_, err := nb.GraphQL("{ device_list { id } }", nil)
+	if err == nil || !strings.Contains(err.Error(), "3.0 or newer") {
+		t.Errorf("got error %v, want a version error", err)
+	}
+}
+
+func TestFieldValues(t *testing.T) {
+	var data interface{}
+	err := json.Unmarshal([]byte(`{
+		"name": "node001",
+		"site": {"slug": "de1"},
+		"tags": [],
+		"interfaces": [
+			{"name": "eth0", "ip_addresses": [{"address": "10.0.0.1/24"}, {"address": "10.0.0.2/24"}]},
+			{"name": "eth1", "ip_addresses": []},
+			{"name": "eth2", "ip_addresses": [{"address": null}]}
+		]
+	}`), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want []interface{}
+	}{
+		{"name", []interface{}{"node001"}},
+		{"site.slug", []interface{}{"de1"}},
+		{"interfaces.name", []interface{}{"eth0", "eth1", "eth2"}},
+		{"interfaces.ip_addresses.address", []interface{}{"10.0.0.1/24", "10.0.0.2/24"}},
+		{"tags", nil},
+		{"site.missing", nil},
+		{"missing.slug", nil},
+	}
+	for _, tt := range tests {
+		if got := FieldValues(data, tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("FieldValues(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
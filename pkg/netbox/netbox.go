@@ -18,24 +18,34 @@ package netbox
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
+	"net/url"
 	"strconv"
 	"time"
 
 	"github.com/netbox-community/go-netbox/netbox/client/virtualization"
 
-	runtimeclient "github.com/go-openapi/runtime/client"
+	"github.com/go-openapi/runtime"
 	netboxclient "github.com/netbox-community/go-netbox/netbox/client"
+	"github.com/netbox-community/go-netbox/netbox/client/circuits"
 	"github.com/netbox-community/go-netbox/netbox/client/dcim"
 	"github.com/netbox-community/go-netbox/netbox/client/ipam"
+	"github.com/netbox-community/go-netbox/netbox/client/tenancy"
 	"github.com/netbox-community/go-netbox/netbox/models"
 )
 
 const netboxDefaultHost = "netbox.global.cloud.sap"
 
 type Netbox struct {
-	client *netboxclient.NetBoxAPI
+	client   *netboxclient.NetBoxAPI
+	graphql  runtime.ClientTransport
+	cache    *cache
+	cacheTTL CacheTTL
+	version  *versionState
+	ctx      context.Context
+	timeout  time.Duration
 }
 
 // NewDefaultHost creates a Netbox instance for the default host
@@ -45,21 +55,53 @@ func NewDefaultHost(token string) (*Netbox, error) {
 
 // New creates a Netbox instance with the host and token
 func New(host, token string) (*Netbox, error) {
-	client, err := client(host, token)
+	return NewWithConfig(host, token, ClientConfig{})
+}
+
+// NewWithConfig creates a Netbox instance with the host and token, connecting as configured
+func NewWithConfig(host, token string, cfg ClientConfig) (*Netbox, error) {
+	client, err := client(host, token, cfg)
 	if err != nil {
 		return nil, err
 	}
-	return &Netbox{client: client}, nil
+	graphql, err := transport(host, cfg.graphqlPath(), token, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Netbox{
+		client:  client,
+		graphql: graphql,
+		cache:   sharedCache(host, token),
+		version: &versionState{},
+		ctx:     context.Background(),
+		timeout: cfg.timeout(),
+	}, nil
 }
 
 // Sites retrieves the all sites in the region
 func (nb *Netbox) Sites(region string) ([]models.Site, error) {
 	result := make([]models.Site, 0)
 	params := dcim.NewDcimSitesListParams()
-	params.WithContext(context.Background())
+	params.WithTimeout(nb.timeout)
+	params.WithContext(nb.ctx)
 	params.Region = &region
 	limit := int64(50)
 	params.Limit = &limit
+	modern, err := nb.modern()
+	if err != nil {
+		return nil, err
+	}
+	if modern {
+		err = nb.List("/dcim/sites/", params, nil, func(r json.RawMessage) error {
+			var site models.Site
+			if err := decode(r, &site); err != nil {
+				return err
+			}
+			result = append(result, site)
+			return nil
+		})
+		return result, err
+	}
 
 	for {
 		offset := int64(0)
@@ -82,11 +124,12 @@ func (nb *Netbox) Sites(region string) ([]models.Site, error) {
 	return result, nil
 }
 
-// Racks retrieves all the racks with the specified role in the site
-func (nb *Netbox) Racks(role string, siteID string) ([]models.Rack, error) {
+// Racks retrieves all the racks with the specified role in the site, filtered further by the raw query
+func (nb *Netbox) Racks(role string, siteID string, rawQuery RawQuery) ([]models.Rack, error) {
 	result := make([]models.Rack, 0)
 	params := dcim.NewDcimRacksListParams()
-	params.WithContext(context.Background())
+	params.WithTimeout(nb.timeout)
+	params.WithContext(nb.ctx)
 	if role != "" {
 		params.Role = &role
 	}
@@ -95,6 +138,21 @@ func (nb *Netbox) Racks(role string, siteID string) ([]models.Rack, error) {
 	}
 	limit := int64(50)
 	params.Limit = &limit
+	modern, err := nb.modern()
+	if err != nil {
+		return nil, err
+	}
+	if len(rawQuery) > 0 || modern {
+		err = nb.List("/dcim/racks/", params, rawQuery.Values(), func(r json.RawMessage) error {
+			var rack models.Rack
+			if err := decode(r, &rack); err != nil {
+				return err
+			}
+			result = append(result, rack)
+			return nil
+		})
+		return result, err
+	}
 
 	for {
 		offset := int64(0)
@@ -120,76 +178,48 @@ func (nb *Netbox) Racks(role string, siteID string) ([]models.Rack, error) {
 
 // Servers retrieves all the servers in the rack
 func (nb *Netbox) Servers(rackID string) ([]models.DeviceWithConfigContext, error) {
-	result := make([]models.DeviceWithConfigContext, 0)
 	params := dcim.NewDcimDevicesListParams()
-	params.WithContext(context.Background())
 	params.RackID = &rackID
 	role := "server"
 	params.Role = &role
-	limit := int64(50)
-	params.Limit = &limit
-
-	for {
-		offset := int64(0)
-		if params.Offset != nil {
-			offset = *params.Offset + limit
-		}
-		params.Offset = &offset
-		list, err := nb.client.Dcim.DcimDevicesList(params, nil)
-		if err != nil {
-			return nil, err
-		}
-		for _, rack := range list.Payload.Results {
-			result = append(result, *rack)
-		}
-		if list.Payload.Next == nil {
-			break
-		}
-	}
-
-	return result, nil
-
+	return nb.DevicesByParams(*params, nil)
 }
 
 //DevicesByRegion retrieves devices by region, manufacturer and status
 func (nb *Netbox) DevicesByRegion(query, manufacturer, region, status string) (res []models.DeviceWithConfigContext, err error) {
-	res = make([]models.DeviceWithConfigContext, 0)
 	params := dcim.NewDcimDevicesListParams()
 	params.WithQ(&query)
 	params.WithRegion(&region)
 	params.WithManufacturer(&manufacturer)
 	params.WithStatus(&status)
-	params.WithContext(context.Background())
-	limit := int64(100)
-	params.WithLimit(&limit)
-	for {
-		offset := int64(0)
-		if params.Offset != nil {
-			offset = *params.Offset + limit
-		}
-		params.Offset = &offset
-		list, err := nb.client.Dcim.DcimDevicesList(params, nil)
-		fmt.Println(*params)
-		if err != nil {
-			return res, err
-		}
-		for _, device := range list.Payload.Results {
-			res = append(res, *device)
-		}
-		if list.Payload.Next == nil {
-			break
-		}
-	}
-	return res, err
+	return nb.DevicesByParams(*params, nil)
 }
 
 //DevicesByRegion retrieves devices by region, manufacturer and status
-func (nb *Netbox) DevicesByParams(params dcim.DcimDevicesListParams) (res []models.DeviceWithConfigContext, err error) {
+func (nb *Netbox) DevicesByParams(params dcim.DcimDevicesListParams, rawQuery RawQuery) (res []models.DeviceWithConfigContext, err error) {
 	res = make([]models.DeviceWithConfigContext, 0)
 	limit := int64(100)
 	params.WithLimit(&limit)
-	params.WithTimeout(30 * time.Second)
-	params.WithContext(context.Background())
+	params.WithTimeout(nb.timeout)
+	params.WithContext(nb.ctx)
+	if params.Status, err = nb.statusParam(params.Status); err != nil {
+		return
+	}
+	modern, err := nb.modern()
+	if err != nil {
+		return
+	}
+	if len(rawQuery) > 0 || modern {
+		err = nb.List("/dcim/devices/", &params, rawQuery.Values(), func(r json.RawMessage) error {
+			var device models.DeviceWithConfigContext
+			if err := decode(r, &device); err != nil {
+				return err
+			}
+			res = append(res, device)
+			return nil
+		})
+		return res, err
+	}
 
 	for {
 		offset := int64(0)
@@ -215,8 +245,20 @@ func (nb *Netbox) DevicesByParams(params dcim.DcimDevicesListParams) (res []mode
 func (nb *Netbox) DeviceByParams(params dcim.DcimDevicesListParams) (res models.DeviceWithConfigContext, err error) {
 	limit := int64(1)
 	params.WithLimit(&limit)
-	params.WithContext(context.Background())
-	params.WithTimeout(30 * time.Second)
+	params.WithContext(nb.ctx)
+	params.WithTimeout(nb.timeout)
+	modern, err := nb.modern()
+	if err != nil {
+		return
+	}
+	if modern {
+		page, err := nb.listPage("/dcim/devices/", &params, nil, 1, 0)
+		if err != nil || len(page.Results) == 0 {
+			return res, err
+		}
+		err = decode(page.Results[0], &res)
+		return res, err
+	}
 
 	list, err := nb.client.Dcim.DcimDevicesList(&params, nil)
 	if err != nil {
@@ -230,12 +272,30 @@ func (nb *Netbox) DeviceByParams(params dcim.DcimDevicesListParams) (res models.
 }
 
 //VMsByTag retrieves devices by region, manufacturer and status
-func (nb *Netbox) VMsByParams(params virtualization.VirtualizationVirtualMachinesListParams) (res []models.VirtualMachineWithConfigContext, err error) {
+func (nb *Netbox) VMsByParams(params virtualization.VirtualizationVirtualMachinesListParams, rawQuery RawQuery) (res []models.VirtualMachineWithConfigContext, err error) {
 	res = make([]models.VirtualMachineWithConfigContext, 0)
-	params.WithTimeout(30 * time.Second)
+	params.WithTimeout(nb.timeout)
 	limit := int64(100)
 	params.WithLimit(&limit)
-	params.WithContext(context.Background())
+	params.WithContext(nb.ctx)
+	if params.Status, err = nb.statusParam(params.Status); err != nil {
+		return
+	}
+	modern, err := nb.modern()
+	if err != nil {
+		return
+	}
+	if len(rawQuery) > 0 || modern {
+		err = nb.List("/virtualization/virtual-machines/", &params, rawQuery.Values(), func(r json.RawMessage) error {
+			var vm models.VirtualMachineWithConfigContext
+			if err := decode(r, &vm); err != nil {
+				return err
+			}
+			res = append(res, vm)
+			return nil
+		})
+		return res, err
+	}
 	for {
 		offset := int64(0)
 		if params.Offset != nil {
@@ -256,28 +316,40 @@ func (nb *Netbox) VMsByParams(params virtualization.VirtualizationVirtualMachine
 	return res, err
 }
 
-//VMsByTag retrieves devices by region, manufacturer and status
-func (nb *Netbox) VMsByTag(query, status, tag string) (res []models.VirtualMachineWithConfigContext, err error) {
-	res = make([]models.VirtualMachineWithConfigContext, 0)
-	params := virtualization.NewVirtualizationVirtualMachinesListParams()
-	params.WithQ(&query)
-	params.WithContext(context.Background())
-	params.WithStatus(&status)
-	params.WithTag(&tag)
+// IPAddressesByParams retrieves ip addresses by the ipam list params
+func (nb *Netbox) IPAddressesByParams(params ipam.IpamIPAddressesListParams, rawQuery RawQuery) (res []models.IPAddress, err error) {
+	res = make([]models.IPAddress, 0)
+	params.WithTimeout(nb.timeout)
 	limit := int64(100)
 	params.WithLimit(&limit)
+	params.WithContext(nb.ctx)
+	modern, err := nb.modern()
+	if err != nil {
+		return
+	}
+	if len(rawQuery) > 0 || modern {
+		err = nb.List("/ipam/ip-addresses/", &params, rawQuery.Values(), func(r json.RawMessage) error {
+			var ip models.IPAddress
+			if err := decode(r, &ip); err != nil {
+				return err
+			}
+			res = append(res, ip)
+			return nil
+		})
+		return res, err
+	}
 	for {
 		offset := int64(0)
 		if params.Offset != nil {
 			offset = *params.Offset + limit
 		}
 		params.Offset = &offset
-		list, err := nb.client.Virtualization.VirtualizationVirtualMachinesList(params, nil)
+		list, err := nb.client.Ipam.IpamIPAddressesList(&params, nil)
 		if err != nil {
 			return res, err
 		}
-		for _, vm := range list.Payload.Results {
-			res = append(res, *vm)
+		for _, ip := range list.Payload.Results {
+			res = append(res, *ip)
 		}
 		if list.Payload.Next == nil {
 			break
@@ -286,64 +358,455 @@ func (nb *Netbox) VMsByTag(query, status, tag string) (res []models.VirtualMachi
 	return res, err
 }
 
-// ManagementIP retrieves the IP of the management interface for server
-func (nb *Netbox) ManagementIPs(serverID string) (ips []string, err error) {
+// PrefixesByParams retrieves prefixes by the ipam list params
+func (nb *Netbox) PrefixesByParams(params ipam.IpamPrefixesListParams, rawQuery RawQuery) (res []models.Prefix, err error) {
+	res = make([]models.Prefix, 0)
+	params.WithTimeout(nb.timeout)
+	limit := int64(100)
+	params.WithLimit(&limit)
+	params.WithContext(nb.ctx)
+	modern, err := nb.modern()
+	if err != nil {
+		return
+	}
+	if len(rawQuery) > 0 || modern {
+		err = nb.List("/ipam/prefixes/", &params, rawQuery.Values(), func(r json.RawMessage) error {
+			var prefix models.Prefix
+			if err := decode(r, &prefix); err != nil {
+				return err
+			}
+			res = append(res, prefix)
+			return nil
+		})
+		return res, err
+	}
+	for {
+		offset := int64(0)
+		if params.Offset != nil {
+			offset = *params.Offset + limit
+		}
+		params.Offset = &offset
+		list, err := nb.client.Ipam.IpamPrefixesList(&params, nil)
+		if err != nil {
+			return res, err
+		}
+		for _, prefix := range list.Payload.Results {
+			res = append(res, *prefix)
+		}
+		if list.Payload.Next == nil {
+			break
+		}
+	}
+	return res, err
+}
 
-	managementInterface, err := nb.MgmtInterface(serverID, true)
+// Service is a netbox service. Netbox 2.10 replaced the port of services by a list of ports,
+// which the go-netbox model doesn't know about, Port is only set by older netboxes.
+type Service struct {
+	models.Service
+	Ports []int64 `json:"ports"`
+}
+
+// ServicesByParams retrieves services by the ipam list params. They are always listed raw, as the generated client
+// drops the ports of netbox 2.10 and newer.
+func (nb *Netbox) ServicesByParams(params ipam.IpamServicesListParams, rawQuery RawQuery) (res []Service, err error) {
+	res = make([]Service, 0)
+	params.WithTimeout(nb.timeout)
+	params.WithContext(nb.ctx)
+	err = nb.List("/ipam/services/", &params, rawQuery.Values(), func(r json.RawMessage) error {
+		var service Service
+		if err := decode(r, &service); err != nil {
+			return err
+		}
+		res = append(res, service)
+		return nil
+	})
+	return res, err
+}
+
+// PrimaryIP retrieves the primary ip of the device or, if vm is true, of the virtual machine
+func (nb *Netbox) PrimaryIP(id int64, vm bool) (ip string, err error) {
+	ids := strconv.FormatInt(id, 10)
+	if vm {
+		var vms []models.VirtualMachineWithConfigContext
+		vms, err = nb.VMsByParams(virtualization.VirtualizationVirtualMachinesListParams{ID: &ids}, nil)
+		if err != nil {
+			return
+		}
+		if len(vms) != 1 {
+			return ip, fmt.Errorf("no vm found with id %d", id)
+		}
+		return nb.GetNestedDeviceIP(vms[0].PrimaryIP)
+	}
+	dev, err := nb.DeviceByParams(dcim.DcimDevicesListParams{ID: &ids})
 	if err != nil {
 		return
 	}
-	ips = make([]string, 0)
-	for _, intf := range managementInterface {
-		managementIPAddress, err := nb.IPAddressByDeviceAndIntefrace(serverID, strconv.FormatInt(intf.ID, 10))
+	if dev.ID != id {
+		return ip, fmt.Errorf("no device found with id %d", id)
+	}
+	return nb.GetNestedDeviceIP(dev.PrimaryIP)
+}
+
+// CircuitsByParams retrieves circuits by the circuits list params
+func (nb *Netbox) CircuitsByParams(params circuits.CircuitsCircuitsListParams, rawQuery RawQuery) (res []models.Circuit, err error) {
+	res = make([]models.Circuit, 0)
+	params.WithTimeout(nb.timeout)
+	limit := int64(100)
+	params.WithLimit(&limit)
+	params.WithContext(nb.ctx)
+	modern, err := nb.modern()
+	if err != nil {
+		return
+	}
+	if len(rawQuery) > 0 || modern {
+		err = nb.List("/circuits/circuits/", &params, rawQuery.Values(), func(r json.RawMessage) error {
+			var circuit models.Circuit
+			if err := decode(r, &circuit); err != nil {
+				return err
+			}
+			res = append(res, circuit)
+			return nil
+		})
+		if err != nil || !modern {
+			return res, err
+		}
+		err = nb.setCircuitTerminations(res)
+		return res, err
+	}
+	for {
+		offset := int64(0)
+		if params.Offset != nil {
+			offset = *params.Offset + limit
+		}
+		params.Offset = &offset
+		list, err := nb.client.Circuits.CircuitsCircuitsList(&params, nil)
 		if err != nil {
-			return ips, err
+			return res, err
 		}
-		if managementIPAddress.Address == nil {
-			return ips, fmt.Errorf("no ip address for device %s", serverID)
+		for _, circuit := range list.Payload.Results {
+			res = append(res, *circuit)
 		}
-		ip, _, err := net.ParseCIDR(*managementIPAddress.Address)
+		if list.Payload.Next == nil {
+			break
+		}
+	}
+	return res, err
+}
+
+// setCircuitTerminations replaces the terminations of the circuits by the full ones. Circuits of netbox 3.x only
+// carry brief terminations without connected endpoint. Terminations which are cabled to an interface directly
+// but have no path (e.g. no far end), get the interface at the other end of the cable as connected endpoint.
+func (nb *Netbox) setCircuitTerminations(circuits []models.Circuit) error {
+	ids := make([]int64, 0, len(circuits))
+	index := make(map[int64]int, len(circuits))
+	for i, c := range circuits {
+		circuits[i].Terminationa = nil
+		circuits[i].Terminationz = nil
+		ids = append(ids, c.ID)
+		index[c.ID] = i
+	}
+	for _, chunk := range chunkIDs(ids) {
+		err := nb.List("/circuits/circuit-terminations/", nil, url.Values{"circuit_id": chunk}, func(r json.RawMessage) error {
+			var t struct {
+				models.CircuitCircuitTermination
+				Circuit *struct {
+					ID int64 `json:"id"`
+				} `json:"circuit"`
+				TermSide              string                  `json:"term_side"`
+				ConnectedEndpointType string                  `json:"connected_endpoint_type"`
+				LinkPeerType          string                  `json:"link_peer_type"`
+				LinkPeer              *models.NestedInterface `json:"link_peer"`
+			}
+			if err := decode(r, &t); err != nil {
+				return err
+			}
+			if t.Circuit == nil {
+				return nil
+			}
+			i, ok := index[t.Circuit.ID]
+			if !ok {
+				return nil
+			}
+			termination := t.CircuitCircuitTermination
+			if t.ConnectedEndpointType != "" && t.ConnectedEndpointType != "dcim.interface" {
+				termination.ConnectedEndpoint = nil
+			}
+			if termination.ConnectedEndpoint == nil && t.LinkPeerType == "dcim.interface" {
+				termination.ConnectedEndpoint = t.LinkPeer
+			}
+			switch t.TermSide {
+			case "A":
+				circuits[i].Terminationa = &termination
+			case "Z":
+				circuits[i].Terminationz = &termination
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PDUsByParams retrieves the devices with power outlets by the dcim list params
+func (nb *Netbox) PDUsByParams(params dcim.DcimDevicesListParams, rawQuery RawQuery) (res []models.DeviceWithConfigContext, err error) {
+	res = make([]models.DeviceWithConfigContext, 0)
+	params.WithTimeout(nb.timeout)
+	params.WithContext(nb.ctx)
+	query := rawQuery.Values()
+	query.Set("power_outlets", "true")
+	if params.Status, err = nb.statusParam(params.Status); err != nil {
+		return
+	}
+	err = nb.List("/dcim/devices/", &params, query, func(r json.RawMessage) error {
+		var device models.DeviceWithConfigContext
+		if err := decode(r, &device); err != nil {
+			return err
+		}
+		res = append(res, device)
+		return nil
+	})
+	return res, err
+}
+
+// PowerFeed retrieves the power feed by its ID
+func (nb *Netbox) PowerFeed(id int64) (*models.PowerFeed, error) {
+	modern, err := nb.modern()
+	if err != nil {
+		return nil, err
+	}
+	if modern {
+		var feed models.PowerFeed
+		err = nb.get(fmt.Sprintf("/dcim/power-feeds/%d/", id), &feed)
+		return &feed, err
+	}
+	params := dcim.NewDcimPowerFeedsReadParams()
+	params.WithTimeout(nb.timeout)
+	params.WithContext(nb.ctx)
+	params.ID = id
+	res, err := nb.client.Dcim.DcimPowerFeedsRead(params, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Payload, nil
+}
+
+//VMsByTag retrieves devices by region, manufacturer and status
+func (nb *Netbox) VMsByTag(query, status, tag string) (res []models.VirtualMachineWithConfigContext, err error) {
+	params := virtualization.NewVirtualizationVirtualMachinesListParams()
+	params.WithQ(&query)
+	params.WithStatus(&status)
+	params.WithTag(&tag)
+	return nb.VMsByParams(*params, nil)
+}
+
+// DeviceIP is an IP address together with the interface it is assigned to
+type DeviceIP struct {
+	Address   string
+	Interface string
+	Family    string
+}
+
+// NewDeviceIP parses a plain or CIDR notated address into a DeviceIP
+func NewDeviceIP(address, interfaceName string) (ip DeviceIP, err error) {
+	parsed, _, err := net.ParseCIDR(address)
+	if err != nil {
+		parsed = net.ParseIP(address)
+		if parsed == nil {
+			return ip, fmt.Errorf("invalid ip address %s", address)
+		}
+		err = nil
+	}
+	ip = DeviceIP{
+		Address:   parsed.String(),
+		Interface: interfaceName,
+		Family:    "ipv6",
+	}
+	if parsed.To4() != nil {
+		ip.Family = "ipv4"
+	}
+	return ip, err
+}
+
+// ManagementIPs retrieves the IP of the management interface for server
+func (nb *Netbox) ManagementIPs(serverID string) (ips []string, err error) {
+	deviceIPs, err := nb.ManagementInterfaceIPs(serverID)
+	if err != nil {
+		return
+	}
+	ips = make([]string, 0, len(deviceIPs))
+	for _, ip := range deviceIPs {
+		ips = append(ips, ip.Address)
+	}
+	return
+}
 
+// ManagementInterfaceIPs retrieves all IPs of all management interfaces for server
+func (nb *Netbox) ManagementInterfaceIPs(serverID string) (ips []DeviceIP, err error) {
+	managementInterface, err := nb.MgmtInterface(serverID, true)
+	if err != nil {
+		return
+	}
+	ips = make([]DeviceIP, 0)
+	for _, intf := range managementInterface {
+		intfIPs, err := nb.InterfaceIPs(serverID, intf.ID, interfaceName(intf))
 		if err != nil {
 			return ips, err
 		}
-		ips = append(ips, ip.String())
+		ips = append(ips, intfIPs...)
 	}
 	return
 }
 
 // DeviceInterfaceNameIPs retrieves the IP of the named interface for server
 func (nb *Netbox) DeviceInterfaceNameIPs(name, deviceID string) (ips []string, err error) {
-	var intf *models.Interface
-	var ipAddr *models.IPAddress
-	intf, err = nb.Interface(deviceID, name)
+	deviceIPs, err := nb.InterfaceNameIPs(name, deviceID)
+	if err != nil {
+		return
+	}
+	ips = make([]string, 0, len(deviceIPs))
+	for _, ip := range deviceIPs {
+		ips = append(ips, ip.Address)
+	}
+	return
+}
+
+// InterfaceNameIPs retrieves all IPs of the named interface for server
+func (nb *Netbox) InterfaceNameIPs(name, deviceID string) (ips []DeviceIP, err error) {
+	intf, err := nb.Interface(deviceID, name)
 	if err != nil {
 		return ips, fmt.Errorf("Error getting interface from device: %s. Error: %s", deviceID, err.Error())
 	}
-	ipAddr, err = nb.IPAddressByDeviceAndIntefrace(deviceID, strconv.FormatInt(intf.ID, 10))
+	ips, err = nb.InterfaceIPs(deviceID, intf.ID, name)
 	if err != nil {
 		return ips, fmt.Errorf("Error getting ip from device interface: %s. Error: %s", deviceID, err.Error())
 	}
-	var ip net.IP
-	ip, _, err = net.ParseCIDR(*ipAddr.Address)
+	if len(ips) == 0 {
+		return ips, fmt.Errorf("no ip found for device %s and interface %s", deviceID, name)
+	}
+	return
+}
+
+// InterfaceIPs retrieves all IP addresses assigned to the interface of the device
+func (nb *Netbox) InterfaceIPs(deviceID string, intfID int64, interfaceName string) ([]DeviceIP, error) {
+	v, err := nb.cached(cacheIPAddresses, fmt.Sprintf("%s/interface/%d/%s", deviceID, intfID, interfaceName), func() (interface{}, error) {
+		return nb.interfaceIPs(deviceID, intfID, interfaceName)
+	})
 	if err != nil {
-		return ips, fmt.Errorf("Error parsing ip from device: %s. Error: %s", deviceID, err.Error())
+		return make([]DeviceIP, 0), err
 	}
-	ips = append(ips, ip.String())
+	return v.([]DeviceIP), nil
+}
 
-	return
+func (nb *Netbox) interfaceIPs(deviceID string, intfID int64, interfaceName string) (ips []DeviceIP, err error) {
+	ips = make([]DeviceIP, 0)
+	interfaceID := strconv.FormatInt(intfID, 10)
+	params := ipam.NewIpamIPAddressesListParams()
+	params.DeviceID = &deviceID
+	params.InterfaceID = &interfaceID
+	params.WithTimeout(nb.timeout)
+	params.WithContext(nb.ctx)
+	limit := int64(50)
+	params.Limit = &limit
+	modern, err := nb.modern()
+	if err != nil {
+		return
+	}
+	if modern {
+		err = nb.List("/ipam/ip-addresses/", params, nil, func(r json.RawMessage) error {
+			var addr models.IPAddress
+			if err := decode(r, &addr); err != nil {
+				return err
+			}
+			if addr.Address == nil {
+				return nil
+			}
+			ip, err := NewDeviceIP(*addr.Address, interfaceName)
+			if err != nil {
+				return err
+			}
+			ips = append(ips, ip)
+			return nil
+		})
+		return ips, err
+	}
+
+	for {
+		offset := int64(0)
+		if params.Offset != nil {
+			offset = *params.Offset + limit
+		}
+		params.Offset = &offset
+		list, err := nb.client.Ipam.IpamIPAddressesList(params, nil)
+		if err != nil {
+			return ips, err
+		}
+		for _, addr := range list.Payload.Results {
+			if addr.Address == nil {
+				continue
+			}
+			ip, err := NewDeviceIP(*addr.Address, interfaceName)
+			if err != nil {
+				return ips, err
+			}
+			ips = append(ips, ip)
+		}
+		if list.Payload.Next == nil {
+			break
+		}
+	}
+	return ips, nil
+}
+
+func interfaceName(intf *models.Interface) string {
+	if intf.Name == nil {
+		return ""
+	}
+	return *intf.Name
 }
 
 // Interface retrieves the interface on the device
 func (nb *Netbox) Interface(deviceID string, interfaceName string) (*models.Interface, error) {
+	v, err := nb.cached(cacheInterfaces, fmt.Sprintf("%s/name/%s", deviceID, interfaceName), func() (interface{}, error) {
+		return nb.interfaceByName(deviceID, interfaceName)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*models.Interface), nil
+}
+
+func (nb *Netbox) interfaceByName(deviceID string, interfaceName string) (*models.Interface, error) {
 	params := dcim.NewDcimInterfacesListParams()
 	params.DeviceID = &deviceID
 	params.Name = &interfaceName
-	params.WithContext(context.Background())
+	params.WithTimeout(nb.timeout)
+	params.WithContext(nb.ctx)
 
 	limit := int64(1)
 	params.Limit = &limit
 
+	modern, err := nb.modern()
+	if err != nil {
+		return nil, err
+	}
+	if modern {
+		page, err := nb.listPage("/dcim/interfaces/", params, nil, 1, 0)
+		if err != nil {
+			return nil, err
+		}
+		if page.Count < 1 {
+			return nil, fmt.Errorf("no %s interface found for device %s", interfaceName, deviceID)
+		}
+		if page.Count > 1 {
+			return nil, fmt.Errorf("more than 1 %s interface found for device %s", interfaceName, deviceID)
+		}
+		intf := new(models.Interface)
+		return intf, decode(page.Results[0], intf)
+	}
+
 	list, err := nb.client.Dcim.DcimInterfacesList(params, nil)
 	if err != nil {
 		return nil, err
@@ -359,17 +822,72 @@ func (nb *Netbox) Interface(deviceID string, interfaceName string) (*models.Inte
 
 }
 
+// Interface is a netbox interface with the custom fields netbox 2.10 added to interfaces,
+// which the go-netbox model doesn't know about
+type Interface struct {
+	models.Interface
+	CustomFields map[string]interface{} `json:"custom_fields"`
+}
+
+// InterfacePeer returns the device and interface name at the far end of the cable trace of the interface.
+// ok is false if the interface is not connected to another device interface.
+func InterfacePeer(intf models.Interface) (device, name string, ok bool) {
+	if intf.ConnectedEndpointType != "dcim.interface" {
+		return
+	}
+	endpoint, isMap := intf.ConnectedEndpoint.(map[string]interface{})
+	if !isMap {
+		return
+	}
+	name, _ = endpoint["name"].(string)
+	if d, isMap := endpoint["device"].(map[string]interface{}); isMap {
+		device, _ = d["name"].(string)
+	}
+	return device, name, device != "" || name != ""
+}
+
 // MgmtInterface retrieves the management interface on the device
 func (nb *Netbox) MgmtInterface(deviceID string, mgmtOnly bool) ([]*models.Interface, error) {
+	v, err := nb.cached(cacheInterfaces, fmt.Sprintf("%s/mgmt/%t", deviceID, mgmtOnly), func() (interface{}, error) {
+		return nb.mgmtInterface(deviceID, mgmtOnly)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*models.Interface), nil
+}
+
+func (nb *Netbox) mgmtInterface(deviceID string, mgmtOnly bool) ([]*models.Interface, error) {
 	mgmtOnlyString := strconv.FormatBool(mgmtOnly)
 	params := dcim.NewDcimInterfacesListParams()
 	params.DeviceID = &deviceID
 	params.MgmtOnly = &mgmtOnlyString
-	params.WithContext(context.Background())
+	params.WithTimeout(nb.timeout)
+	params.WithContext(nb.ctx)
 
 	limit := int64(2)
 	params.Limit = &limit
 
+	modern, err := nb.modern()
+	if err != nil {
+		return nil, err
+	}
+	if modern {
+		page, err := nb.listPage("/dcim/interfaces/", params, nil, int(limit), 0)
+		if err != nil {
+			return nil, err
+		}
+		intfs := make([]*models.Interface, 0, len(page.Results))
+		for _, r := range page.Results {
+			intf := new(models.Interface)
+			if err := decode(r, intf); err != nil {
+				return nil, err
+			}
+			intfs = append(intfs, intf)
+		}
+		return intfs, nil
+	}
+
 	list, err := nb.client.Dcim.DcimInterfacesList(params, nil)
 	if err != nil {
 		return nil, err
@@ -380,14 +898,32 @@ func (nb *Netbox) MgmtInterface(deviceID string, mgmtOnly bool) ([]*models.Inter
 
 // IPAddressByDeviceAndIntefrace retrieves the IP address by device and interface
 func (nb *Netbox) IPAddressByDeviceAndIntefrace(deviceID string, interfaceID string) (*models.IPAddress, error) {
+	v, err := nb.cached(cacheIPAddresses, fmt.Sprintf("%s/interface_ip/%s", deviceID, interfaceID), func() (interface{}, error) {
+		return nb.ipAddressByDeviceAndInterface(deviceID, interfaceID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*models.IPAddress), nil
+}
+
+func (nb *Netbox) ipAddressByDeviceAndInterface(deviceID string, interfaceID string) (*models.IPAddress, error) {
 
 	params := ipam.NewIpamIPAddressesListParams()
 	params.DeviceID = &deviceID
 	params.InterfaceID = &interfaceID
-	params.WithContext(context.Background())
+	params.WithTimeout(nb.timeout)
+	params.WithContext(nb.ctx)
 
 	limit := int64(1)
 	params.Limit = &limit
+	modern, err := nb.modern()
+	if err != nil {
+		return nil, err
+	}
+	if modern {
+		return nb.singleIPAddress(params, fmt.Sprintf("device %s and interface %s", deviceID, interfaceID))
+	}
 	list, err := nb.client.Ipam.IpamIPAddressesList(params, nil)
 	if err != nil {
 		return nil, err
@@ -406,12 +942,30 @@ func (nb *Netbox) IPAddressByDeviceAndIntefrace(deviceID string, interfaceID str
 
 // IPAddress retrieves the IPAddress by its ID
 func (nb *Netbox) IPAddress(id int64) (*models.IPAddress, error) {
+	v, err := nb.cached(cacheIPAddresses, fmt.Sprintf("id/%d", id), func() (interface{}, error) {
+		return nb.ipAddress(id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*models.IPAddress), nil
+}
+
+func (nb *Netbox) ipAddress(id int64) (*models.IPAddress, error) {
 	params := ipam.NewIpamIPAddressesListParams()
-	params.WithContext(context.Background())
+	params.WithTimeout(nb.timeout)
+	params.WithContext(nb.ctx)
 	ids := fmt.Sprintf("%d", id)
 	params.IDn = &ids
 	limit := int64(1)
 	params.Limit = &limit
+	modern, err := nb.modern()
+	if err != nil {
+		return nil, err
+	}
+	if modern {
+		return nb.singleIPAddress(params, fmt.Sprintf("id %d", id))
+	}
 	list, err := nb.client.Ipam.IpamIPAddressesList(params, nil)
 	if err != nil {
 		return nil, err
@@ -428,6 +982,157 @@ func (nb *Netbox) IPAddress(id int64) (*models.IPAddress, error) {
 
 }
 
+// singleIPAddress lists the ip addresses of the params raw, and returns the only one. what describes the query for errors.
+func (nb *Netbox) singleIPAddress(params *ipam.IpamIPAddressesListParams, what string) (*models.IPAddress, error) {
+	page, err := nb.listPage("/ipam/ip-addresses/", params, nil, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if page.Count < 1 {
+		return nil, fmt.Errorf("no ip found for %s", what)
+	}
+	if page.Count > 1 {
+		return nil, fmt.Errorf("more than 1 ip found for %s", what)
+	}
+	ip := new(models.IPAddress)
+	return ip, decode(page.Results[0], ip)
+}
+
+// Site retrieves the site by its ID
+func (nb *Netbox) Site(id int64) (*models.Site, error) {
+	v, err := nb.cached(cacheSites, strconv.FormatInt(id, 10), func() (interface{}, error) {
+		modern, err := nb.modern()
+		if err != nil {
+			return nil, err
+		}
+		if modern {
+			site := new(models.Site)
+			return site, nb.get(fmt.Sprintf("/dcim/sites/%d/", id), site)
+		}
+		params := dcim.NewDcimSitesReadParams()
+		params.WithTimeout(nb.timeout)
+		params.WithContext(nb.ctx)
+		params.ID = id
+		res, err := nb.client.Dcim.DcimSitesRead(params, nil)
+		if err != nil {
+			return nil, err
+		}
+		return res.Payload, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*models.Site), nil
+}
+
+// DeviceType retrieves the device type by its ID
+func (nb *Netbox) DeviceType(id int64) (*models.DeviceType, error) {
+	v, err := nb.cached(cacheDeviceTypes, strconv.FormatInt(id, 10), func() (interface{}, error) {
+		modern, err := nb.modern()
+		if err != nil {
+			return nil, err
+		}
+		if modern {
+			obj := new(models.DeviceType)
+			return obj, nb.get(fmt.Sprintf("/dcim/device-types/%d/", id), obj)
+		}
+		params := dcim.NewDcimDeviceTypesReadParams()
+		params.WithTimeout(nb.timeout)
+		params.WithContext(nb.ctx)
+		params.ID = id
+		res, err := nb.client.Dcim.DcimDeviceTypesRead(params, nil)
+		if err != nil {
+			return nil, err
+		}
+		return res.Payload, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*models.DeviceType), nil
+}
+
+// Region retrieves the region by its ID
+func (nb *Netbox) Region(id int64) (*models.Region, error) {
+	v, err := nb.cached(cacheRegions, strconv.FormatInt(id, 10), func() (interface{}, error) {
+		modern, err := nb.modern()
+		if err != nil {
+			return nil, err
+		}
+		if modern {
+			obj := new(models.Region)
+			return obj, nb.get(fmt.Sprintf("/dcim/regions/%d/", id), obj)
+		}
+		params := dcim.NewDcimRegionsReadParams()
+		params.WithTimeout(nb.timeout)
+		params.WithContext(nb.ctx)
+		params.ID = id
+		res, err := nb.client.Dcim.DcimRegionsRead(params, nil)
+		if err != nil {
+			return nil, err
+		}
+		return res.Payload, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*models.Region), nil
+}
+
+// Tenant retrieves the tenant by its ID
+func (nb *Netbox) Tenant(id int64) (*models.Tenant, error) {
+	v, err := nb.cached(cacheTenants, strconv.FormatInt(id, 10), func() (interface{}, error) {
+		modern, err := nb.modern()
+		if err != nil {
+			return nil, err
+		}
+		if modern {
+			obj := new(models.Tenant)
+			return obj, nb.get(fmt.Sprintf("/tenancy/tenants/%d/", id), obj)
+		}
+		params := tenancy.NewTenancyTenantsReadParams()
+		params.WithTimeout(nb.timeout)
+		params.WithContext(nb.ctx)
+		params.ID = id
+		res, err := nb.client.Tenancy.TenancyTenantsRead(params, nil)
+		if err != nil {
+			return nil, err
+		}
+		return res.Payload, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*models.Tenant), nil
+}
+
+// Cluster retrieves the virtualization cluster by its ID
+func (nb *Netbox) Cluster(id int64) (*models.Cluster, error) {
+	v, err := nb.cached(cacheClusters, strconv.FormatInt(id, 10), func() (interface{}, error) {
+		modern, err := nb.modern()
+		if err != nil {
+			return nil, err
+		}
+		if modern {
+			obj := new(models.Cluster)
+			return obj, nb.get(fmt.Sprintf("/virtualization/clusters/%d/", id), obj)
+		}
+		params := virtualization.NewVirtualizationClustersReadParams()
+		params.WithTimeout(nb.timeout)
+		params.WithContext(nb.ctx)
+		params.ID = id
+		res, err := nb.client.Virtualization.VirtualizationClustersRead(params, nil)
+		if err != nil {
+			return nil, err
+		}
+		return res.Payload, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*models.Cluster), nil
+}
+
 func (nb *Netbox) GetNestedDeviceIP(i *models.NestedIPAddress) (ip string, err error) {
 	var ipnet net.IP
 	if i == nil {
@@ -441,8 +1146,8 @@ func (nb *Netbox) GetNestedDeviceIP(i *models.NestedIPAddress) (ip string, err e
 	return ipnet.String(), err
 }
 
-// RacksByRegion retrieves all the racks in the region with specified role
-func (nb *Netbox) RacksByRegion(role string, region string) ([]models.Rack, error) {
+// RacksByRegion retrieves all the racks in the region with specified role, filtered further by the raw query
+func (nb *Netbox) RacksByRegion(role string, region string, rawQuery RawQuery) ([]models.Rack, error) {
 
 	siteResults, err := nb.Sites(region)
 	if err != nil {
@@ -451,7 +1156,7 @@ func (nb *Netbox) RacksByRegion(role string, region string) ([]models.Rack, erro
 
 	result := make([]models.Rack, 0)
 	for _, s := range siteResults {
-		r, err := nb.Racks(role, strconv.FormatInt(s.ID, 10))
+		r, err := nb.Racks(role, strconv.FormatInt(s.ID, 10), rawQuery)
 		if err != nil {
 			return nil, err
 		}
@@ -463,7 +1168,7 @@ func (nb *Netbox) RacksByRegion(role string, region string) ([]models.Rack, erro
 
 // ServersByRegion retrieves all the servers in the region with the specified rack role
 func (nb *Netbox) ServersByRegion(rackRole string, region string) ([]models.DeviceWithConfigContext, error) {
-	racks, err := nb.RacksByRegion(rackRole, region)
+	racks, err := nb.RacksByRegion(rackRole, region, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -484,45 +1189,18 @@ func (nb *Netbox) ServersByRegion(rackRole string, region string) ([]models.Devi
 
 // AcitveDevicesByCustomParameters retrievs all active devices with custom parameters
 func (nb *Netbox) ActiveDevicesByCustomParameters(query string, params *dcim.DcimDevicesListParams) ([]models.DeviceWithConfigContext, error) {
-	res := make([]models.DeviceWithConfigContext, 0)
 	activeStatus := "1"
-	limit := int64(100)
 	params.WithStatus(&activeStatus)
-	params.WithLimit(&limit)
-	params.WithContext(context.Background())
-	for {
-		offset := int64(0)
-		if params.Offset != nil {
-			offset = *params.Offset + limit
-		}
-		params.Offset = &offset
-		list, err := nb.client.Dcim.DcimDevicesList(params, nil)
-		if err != nil {
-			return res, err
-		}
-		for _, device := range list.Payload.Results {
-			res = append(res, *device)
-		}
-		if list.Payload.Next == nil {
-			break
-		}
-	}
-	return res, nil
+	return nb.DevicesByParams(*params, nil)
 }
 
-func client(host, token string) (*netboxclient.NetBoxAPI, error) {
-
-	tlsClient, err := runtimeclient.TLSClient(runtimeclient.TLSClientOptions{InsecureSkipVerify: true})
+func client(host, token string, cfg ClientConfig) (*netboxclient.NetBoxAPI, error) {
 
+	transport, err := transport(host, cfg.basePath(), token, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	transport := runtimeclient.NewWithClient(host, netboxclient.DefaultBasePath, []string{"https"}, tlsClient)
-	if token != "" {
-		transport.DefaultAuthentication = runtimeclient.APIKeyAuth("Authorization", "header", fmt.Sprintf("Token %v", token))
-	}
-
 	c := netboxclient.New(transport, nil)
 
 	return c, nil
@@ -0,0 +1,192 @@
+/**
+ * Copyright 2020 SAP SE
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netbox
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+
+	"github.com/go-openapi/runtime"
+	"github.com/go-openapi/strfmt"
+)
+
+// rawPage is a netbox list response with undecoded results
+type rawPage struct {
+	Count   int64             `json:"count"`
+	Next    *string           `json:"next"`
+	Results []json.RawMessage `json:"results"`
+}
+
+// List pages through the results of the api path (e.g. "/dcim/power-ports/") and calls fn with the raw json of every result.
+// The generated list params (may be nil) and the query are both written to the request, so query can hold
+// filters the generated params don't know about. The go-netbox models can't decode every netbox response
+// (e.g. connected endpoints), which is why fn gets the raw json.
+func (nb *Netbox) List(path string, params runtime.ClientRequestWriter, query url.Values, fn func(json.RawMessage) error) error {
+	limit := 100
+	for offset := 0; ; offset += limit {
+		page, err := nb.listPage(path, params, query, limit, offset)
+		if err != nil {
+			return err
+		}
+		for _, r := range page.Results {
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+		if page.Next == nil {
+			return nil
+		}
+	}
+}
+
+func (nb *Netbox) listPage(path string, params runtime.ClientRequestWriter, query url.Values, limit, offset int) (page *rawPage, err error) {
+	res, err := nb.client.Transport.Submit(&runtime.ClientOperation{
+		ID:                 "raw_list",
+		Method:             "GET",
+		PathPattern:        path,
+		ProducesMediaTypes: []string{"application/json"},
+		ConsumesMediaTypes: []string{"application/json"},
+		Params: runtime.ClientRequestWriterFunc(func(r runtime.ClientRequest, reg strfmt.Registry) error {
+			if params != nil {
+				if err := params.WriteToRequest(r, reg); err != nil {
+					return err
+				}
+			} else if err := r.SetTimeout(nb.timeout); err != nil {
+				return err
+			}
+			for k, v := range query {
+				if err := r.SetQueryParam(k, v...); err != nil {
+					return err
+				}
+			}
+			if err := r.SetQueryParam("limit", strconv.Itoa(limit)); err != nil {
+				return err
+			}
+			return r.SetQueryParam("offset", strconv.Itoa(offset))
+		}),
+		Reader: runtime.ClientResponseReaderFunc(func(response runtime.ClientResponse, consumer runtime.Consumer) (interface{}, error) {
+			if response.Code() != 200 {
+				return nil, runtime.NewAPIError("unknown error", response, response.Code())
+			}
+			p := new(rawPage)
+			if err := consumer.Consume(response.Body(), p); err != nil {
+				return nil, err
+			}
+			return p, nil
+		}),
+		Context: nb.ctx,
+	})
+	if err != nil {
+		return
+	}
+	return res.(*rawPage), nil
+}
+
+// get reads the single object at the api path (e.g. "/dcim/sites/1/") into out
+func (nb *Netbox) get(path string, out interface{}) error {
+	_, err := nb.client.Transport.Submit(&runtime.ClientOperation{
+		ID:                 "raw_get",
+		Method:             "GET",
+		PathPattern:        path,
+		ProducesMediaTypes: []string{"application/json"},
+		ConsumesMediaTypes: []string{"application/json"},
+		Params: runtime.ClientRequestWriterFunc(func(r runtime.ClientRequest, reg strfmt.Registry) error {
+			return r.SetTimeout(nb.timeout)
+		}),
+		Reader: runtime.ClientResponseReaderFunc(func(response runtime.ClientResponse, consumer runtime.Consumer) (interface{}, error) {
+			if response.Code() != 200 {
+				return nil, runtime.NewAPIError("unknown error", response, response.Code())
+			}
+			var raw json.RawMessage
+			if err := consumer.Consume(response.Body(), &raw); err != nil {
+				return nil, err
+			}
+			return nil, decode(raw, out)
+		}),
+		Context: nb.ctx,
+	})
+	return err
+}
+
+// RawQuery holds query parameters which are sent to netbox as they are. A parameter can have a single value or a
+// list of values, e.g. for filters netbox ORs like site or tag.
+type RawQuery map[string][]string
+
+// UnmarshalYAML accepts a string or a list of strings per parameter
+func (q *RawQuery) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw map[string]queryValues
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	*q = make(RawQuery, len(raw))
+	for k, v := range raw {
+		(*q)[k] = v
+	}
+	return nil
+}
+
+// queryValues are the values of a raw query parameter, decoded as strings like the yaml text
+type queryValues []string
+
+func (v *queryValues) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var values []string
+	if err := unmarshal(&values); err == nil {
+		*v = values
+		return nil
+	}
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	*v = []string{value}
+	return nil
+}
+
+// Values converts the raw query into url values
+func (q RawQuery) Values() url.Values {
+	values := make(url.Values, len(q))
+	for k, v := range q {
+		values[k] = append([]string(nil), v...)
+	}
+	return values
+}
+
+// patch sends the body as json with a PATCH request, e.g. a list of objects to update at once
+func (nb *Netbox) patch(path string, body interface{}) error {
+	_, err := nb.client.Transport.Submit(&runtime.ClientOperation{
+		ID:                 "raw_patch",
+		Method:             "PATCH",
+		PathPattern:        path,
+		ProducesMediaTypes: []string{"application/json"},
+		ConsumesMediaTypes: []string{"application/json"},
+		Params: runtime.ClientRequestWriterFunc(func(r runtime.ClientRequest, reg strfmt.Registry) error {
+			if err := r.SetTimeout(nb.timeout); err != nil {
+				return err
+			}
+			return r.SetBodyParam(body)
+		}),
+		Reader: runtime.ClientResponseReaderFunc(func(response runtime.ClientResponse, consumer runtime.Consumer) (interface{}, error) {
+			if response.Code() != 200 {
+				return nil, runtime.NewAPIError("unknown error", response, response.Code())
+			}
+			return nil, nil
+		}),
+		Context: nb.ctx,
+	})
+	return err
+}
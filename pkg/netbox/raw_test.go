@@ -0,0 +1,63 @@
+package netbox
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestRawQueryUnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    RawQuery
+		wantErr bool
+	}{
+		{
+			name: "single values",
+			yaml: "status: active\nrack_id: 42",
+			want: RawQuery{"status": {"active"}, "rack_id": {"42"}},
+		},
+		{
+			name: "lists",
+			yaml: "site: [de1, de2]\ntag__n: [staging]",
+			want: RawQuery{"site": {"de1", "de2"}, "tag__n": {"staging"}},
+		},
+		{
+			name: "empty",
+			yaml: "{}",
+			want: RawQuery{},
+		},
+		{
+			name:    "nested",
+			yaml:    "site:\n  slug: de1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var q RawQuery
+			err := yaml.Unmarshal([]byte(tt.yaml), &q)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(q, tt.want) {
+				t.Errorf("got %v, want %v", q, tt.want)
+			}
+		})
+	}
+}
+
+func TestRawQueryValues(t *testing.T) {
+	q := RawQuery{"site": {"de1", "de2"}}
+	values := q.Values()
+	if want := (url.Values{"site": {"de1", "de2"}}); !reflect.DeepEqual(values, want) {
+		t.Errorf("Values() = %v, want %v", values, want)
+	}
+	values.Add("site", "de3")
+	if len(q["site"]) != 2 {
+		t.Errorf("Values() shares its slices with the raw query")
+	}
+}
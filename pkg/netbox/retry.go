@@ -0,0 +1,171 @@
+/**
+ * Copyright 2020 SAP SE
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	retryTooManyRequests = "429"
+	retryServerError     = "5xx"
+	retryConnection      = "connection"
+)
+
+// RetryConfig configures how failed netbox requests are retried. Every retry waits twice as long as the one before,
+// with a random jitter. Too many requests (429), server errors (5xx) and connection errors are retried.
+type RetryConfig struct {
+	// MaxAttempts of a request including the first one, defaults to 4. 1 disables retries
+	MaxAttempts int `yaml:"max_attempts"`
+	// InitialBackoff in milliseconds before the first retry, defaults to 500
+	InitialBackoff int `yaml:"initial_backoff"`
+	// MaxBackoff in milliseconds between two attempts, defaults to 30000.
+	// Requests whose Retry-After header asks for a longer wait are given up.
+	MaxBackoff int `yaml:"max_backoff"`
+}
+
+// retryTransport retries the operations of the wrapped transport. Every attempt gets the full request timeout.
+type retryTransport struct {
+	runtime.ClientTransport
+	cfg RetryConfig
+}
+
+var (
+	retryAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "atlas_netbox_request_retries_total",
+		Help: "Number of retried netbox requests by reason",
+	}, []string{"reason"})
+	retryGiveUps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "atlas_netbox_request_giveups_total",
+		Help: "Number of netbox requests which failed after retrying, by reason",
+	}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(retryAttempts, retryGiveUps)
+}
+
+func (cfg RetryConfig) maxAttempts() int {
+	if cfg.MaxAttempts <= 0 {
+		return 4
+	}
+	return cfg.MaxAttempts
+}
+
+func (cfg RetryConfig) maxBackoff() time.Duration {
+	if cfg.MaxBackoff <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(cfg.MaxBackoff) * time.Millisecond
+}
+
+// backoff returns the wait before the retry following the attempt, between half and the full exponential backoff
+func (cfg RetryConfig) backoff(attempt int) time.Duration {
+	backoff := 500 * time.Millisecond
+	if cfg.InitialBackoff > 0 {
+		backoff = time.Duration(cfg.InitialBackoff) * time.Millisecond
+	}
+	for i := 1; i < attempt && backoff < cfg.maxBackoff(); i++ {
+		backoff *= 2
+	}
+	if backoff > cfg.maxBackoff() {
+		backoff = cfg.maxBackoff()
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func (t retryTransport) Submit(op *runtime.ClientOperation) (res interface{}, err error) {
+	ctx := op.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for attempt := 1; ; attempt++ {
+		res, err = t.ClientTransport.Submit(op)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		reason, retryAfter := retryReason(err)
+		if reason == "" {
+			return
+		}
+		wait := t.cfg.backoff(attempt)
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		if attempt >= t.cfg.maxAttempts() || wait > t.cfg.maxBackoff() {
+			retryGiveUps.WithLabelValues(reason).Inc()
+			return
+		}
+		retryAttempts.WithLabelValues(reason).Inc()
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// retryReason returns why the failed request should be retried, and the wait the server asked for.
+// An empty reason means the error is permanent.
+func retryReason(err error) (reason string, retryAfter time.Duration) {
+	var apiError *runtime.APIError
+	var opError *net.OpError
+	var netError net.Error
+	switch {
+	case errors.As(err, &apiError):
+		switch {
+		case apiError.Code == http.StatusTooManyRequests:
+			if res, ok := apiError.Response.(runtime.ClientResponse); ok {
+				retryAfter = parseRetryAfter(res.GetHeader("Retry-After"))
+			}
+			return retryTooManyRequests, retryAfter
+		case apiError.Code >= 500 && apiError.Code != http.StatusNotImplemented:
+			return retryServerError, 0
+		}
+	case errors.As(err, &opError), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return retryConnection, 0
+	case errors.As(err, &netError) && netError.Timeout():
+		return retryConnection, 0
+	}
+	return "", 0
+}
+
+// parseRetryAfter parses the seconds or the date of a Retry-After header
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
@@ -0,0 +1,85 @@
+package netbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/runtime"
+)
+
+// response is a runtime.ClientResponse with headers only
+type response struct {
+	code   int
+	header http.Header
+}
+
+func (r response) Code() int                       { return r.code }
+func (r response) Message() string                 { return http.StatusText(r.code) }
+func (r response) GetHeader(name string) string   { return r.header.Get(name) }
+func (r response) GetHeaders(name string) []string { return r.header.Values(name) }
+func (r response) Body() io.ReadCloser             { return http.NoBody }
+
+func TestRetryReason(t *testing.T) {
+	apiError := func(code int, header http.Header) error {
+		return fmt.Errorf("request failed: %w", runtime.NewAPIError("unknown error", response{code, header}, code))
+	}
+	tests := []struct {
+		name       string
+		err        error
+		reason     string
+		retryAfter time.Duration
+	}{
+		{name: "too many requests", err: apiError(429, nil), reason: retryTooManyRequests},
+		{name: "retry after", err: apiError(429, http.Header{"Retry-After": {"3"}}), reason: retryTooManyRequests, retryAfter: 3 * time.Second},
+		{name: "server error", err: apiError(503, nil), reason: retryServerError},
+		{name: "not implemented", err: apiError(501, nil)},
+		{name: "not found", err: apiError(404, nil)},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, reason: retryConnection},
+		{name: "eof", err: fmt.Errorf("read: %w", io.ErrUnexpectedEOF), reason: retryConnection},
+		{name: "timeout", err: context.DeadlineExceeded, reason: retryConnection},
+		{name: "permanent", err: errors.New("invalid json")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, retryAfter := retryReason(tt.err)
+			if reason != tt.reason || retryAfter != tt.retryAfter {
+				t.Errorf("retryReason() = %q, %s, want %q, %s", reason, retryAfter, tt.reason, tt.retryAfter)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		min, max time.Duration
+	}{
+		{value: ""},
+		{value: "120", min: 2 * time.Minute, max: 2 * time.Minute},
+		{value: time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), min: 58 * time.Second, max: time.Minute},
+		{value: "soon"},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got < tt.min || got > tt.max {
+			t.Errorf("parseRetryAfter(%q) = %s, want between %s and %s", tt.value, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	cfg := RetryConfig{InitialBackoff: 100, MaxBackoff: 1000}
+	for _, tt := range []struct {
+		attempt int
+		max     time.Duration
+	}{{1, 100 * time.Millisecond}, {2, 200 * time.Millisecond}, {3, 400 * time.Millisecond}, {10, time.Second}} {
+		if got := cfg.backoff(tt.attempt); got < tt.max/2 || got > tt.max {
+			t.Errorf("backoff(%d) = %s, want between %s and %s", tt.attempt, got, tt.max/2, tt.max)
+		}
+	}
+}
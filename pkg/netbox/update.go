@@ -0,0 +1,87 @@
+/**
+ * Copyright 2020 SAP SE
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// bulkUpdateSize is the max number of objects updated with one request
+const bulkUpdateSize = 100
+
+// CustomFields are the custom field values to set on the object with the ID. nil values clear the field.
+type CustomFields struct {
+	ID           int64                  `json:"id"`
+	CustomFields map[string]interface{} `json:"custom_fields"`
+}
+
+// UpdateDeviceCustomFields sets the custom fields of the devices, up to bulkUpdateSize devices per request.
+// Other custom fields of the devices are left as they are.
+func (nb *Netbox) UpdateDeviceCustomFields(updates []CustomFields) error {
+	return nb.bulkUpdate("/dcim/devices/", updates)
+}
+
+// UpdateVMCustomFields sets the custom fields of the virtual machines, up to bulkUpdateSize vms per request
+func (nb *Netbox) UpdateVMCustomFields(updates []CustomFields) error {
+	return nb.bulkUpdate("/virtualization/virtual-machines/", updates)
+}
+
+// DeviceCustomFields retrieves the custom fields of the devices which have the custom field set, by device id
+func (nb *Netbox) DeviceCustomFields(field string) (map[int64]map[string]interface{}, error) {
+	return nb.customFields("/dcim/devices/", field)
+}
+
+// VMCustomFields retrieves the custom fields of the virtual machines which have the custom field set, by vm id
+func (nb *Netbox) VMCustomFields(field string) (map[int64]map[string]interface{}, error) {
+	return nb.customFields("/virtualization/virtual-machines/", field)
+}
+
+// customFields lists the objects with a non-empty text custom field. Netbox versions without the empty lookup
+// ignore the filter, so the values are checked again.
+func (nb *Netbox) customFields(path, field string) (values map[int64]map[string]interface{}, err error) {
+	values = make(map[int64]map[string]interface{})
+	err = nb.List(path, nil, url.Values{"cf_" + field + "__empty": {"false"}}, func(r json.RawMessage) error {
+		var object struct {
+			ID           int64                  `json:"id"`
+			CustomFields map[string]interface{} `json:"custom_fields"`
+		}
+		if err := json.Unmarshal(r, &object); err != nil {
+			return err
+		}
+		if v, ok := object.CustomFields[field].(string); ok && v != "" {
+			values[object.ID] = object.CustomFields
+		}
+		return nil
+	})
+	return
+}
+
+// bulkUpdate patches the objects in batches, using the bulk update of netbox 2.10+
+func (nb *Netbox) bulkUpdate(path string, updates []CustomFields) error {
+	for start := 0; start < len(updates); start += bulkUpdateSize {
+		end := start + bulkUpdateSize
+		if end > len(updates) {
+			end = len(updates)
+		}
+		if err := nb.patch(path, updates[start:end]); err != nil {
+			return fmt.Errorf("Error updating %s: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+/**
+ * Copyright 2020 SAP SE
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-openapi/runtime"
+)
+
+// Version is the major and minor version of a netbox server
+type Version struct {
+	Major int
+	Minor int
+}
+
+// versionState holds the negotiated version, shared by all copies of a Netbox instance
+type versionState struct {
+	sync.Mutex
+	version *Version
+}
+
+// legacyVersion is assumed for servers without the status endpoint, which was added with netbox 2.10
+var legacyVersion = Version{Major: 2, Minor: 0}
+
+// legacyStatuses are the integer status values of netbox before 2.7, and the slugs that replaced them
+var legacyStatuses = map[string]string{
+	"0": "offline",
+	"1": "active",
+	"2": "planned",
+	"3": "staged",
+	"4": "failed",
+	"5": "inventory",
+	"6": "decommissioning",
+}
+
+// ParseVersion parses a netbox version like "3.4.2" or "v3.5-beta1"
+func ParseVersion(s string) (v Version, err error) {
+	parts := strings.SplitN(strings.TrimPrefix(s, "v"), ".", 3)
+	if len(parts) < 2 {
+		return v, fmt.Errorf("invalid netbox version %s", s)
+	}
+	if v.Major, err = strconv.Atoi(parts[0]); err != nil {
+		return v, fmt.Errorf("invalid netbox version %s", s)
+	}
+	minor := strings.FieldsFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' })
+	if len(minor) == 0 {
+		return v, fmt.Errorf("invalid netbox version %s", s)
+	}
+	v.Minor, err = strconv.Atoi(minor[0])
+	return
+}
+
+// AtLeast checks if the version is the same or newer than major.minor
+func (v Version) AtLeast(major, minor int) bool {
+	return v.Major > major || (v.Major == major && v.Minor >= minor)
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// Version retrieves the netbox version from the status endpoint. It is only asked once,
+// unless the request fails for another reason than a missing endpoint.
+func (nb *Netbox) Version() (Version, error) {
+	nb.version.Lock()
+	defer nb.version.Unlock()
+	if nb.version.version != nil {
+		return *nb.version.version, nil
+	}
+	var status struct {
+		NetboxVersion string `json:"netbox-version"`
+	}
+	v := legacyVersion
+	err := nb.get("/status/", &status)
+	var apiError *runtime.APIError
+	switch {
+	case errors.As(err, &apiError) && apiError.Code == 404:
+	case err != nil:
+		return v, err
+	default:
+		if v, err = ParseVersion(status.NetboxVersion); err != nil {
+			return v, err
+		}
+	}
+	nb.version.version = &v
+	return v, nil
+}
+
+// modern checks if the server is a netbox 3.x or newer, whose responses the generated client can't decode
+func (nb *Netbox) modern() (bool, error) {
+	v, err := nb.Version()
+	if err != nil {
+		return false, err
+	}
+	return v.AtLeast(3, 0), nil
+}
+
+// statusParam translates legacy integer statuses of a query into slugs, for servers which know their version (2.10+)
+func (nb *Netbox) statusParam(status *string) (*string, error) {
+	if status == nil {
+		return status, nil
+	}
+	v, err := nb.Version()
+	if err != nil {
+		return status, err
+	}
+	slug, ok := legacyStatuses[*status]
+	if v == legacyVersion || !ok {
+		return status, nil
+	}
+	return &slug, nil
+}
+
+// decode unmarshals the json of a netbox object into out, after converting newer response shapes into
+// the ones the go-netbox models expect.
+func decode(r json.RawMessage, out interface{}) error {
+	var data map[string]interface{}
+	if err := json.Unmarshal(r, &data); err != nil {
+		return err
+	}
+	normalize(data)
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+func normalize(data map[string]interface{}) {
+	// netbox 2.11 added display, 3.0 dropped display_name
+	if _, ok := data["display_name"]; !ok && data["display"] != nil {
+		data["display_name"] = data["display"]
+	}
+	// netbox 4.0 renamed the device_role of devices to role
+	if _, ok := data["device_type"]; ok && data["device_role"] == nil && data["role"] != nil {
+		data["device_role"] = data["role"]
+	}
+	// primary_ip is only computed from primary_ip4 and primary_ip6, fall back to them if it is missing
+	if data["primary_ip"] == nil {
+		if data["primary_ip4"] != nil {
+			data["primary_ip"] = data["primary_ip4"]
+		} else if data["primary_ip6"] != nil {
+			data["primary_ip"] = data["primary_ip6"]
+		}
+	}
+	// netbox 3.3 replaced the connected endpoint by a list of connected endpoints
+	if endpoints, ok := data["connected_endpoints"].([]interface{}); ok && data["connected_endpoint"] == nil {
+		if len(endpoints) > 0 {
+			data["connected_endpoint"] = endpoints[0]
+		}
+		data["connected_endpoint_type"] = data["connected_endpoints_type"]
+	}
+	// netbox 3.0 renamed the cable peer to link peer, 3.3 replaced it by a list of link peers
+	if data["link_peer"] == nil && data["cable_peer"] != nil {
+		data["link_peer"] = data["cable_peer"]
+		data["link_peer_type"] = data["cable_peer_type"]
+	}
+	if peers, ok := data["link_peers"].([]interface{}); ok && data["link_peer"] == nil {
+		if len(peers) > 0 {
+			data["link_peer"] = peers[0]
+		}
+		data["link_peer_type"] = data["link_peers_type"]
+	}
+	// netbox 3.x returns decimals as numbers instead of strings, rack positions can be half units
+	if position, ok := data["position"].(float64); ok {
+		data["position"] = math.Floor(position)
+	}
+	for _, field := range []string{"latitude", "longitude"} {
+		if f, ok := data[field].(float64); ok {
+			data[field] = strconv.FormatFloat(f, 'f', -1, 64)
+		}
+	}
+	// netbox 3.x returns created as timestamp instead of date
+	if created, ok := data["created"].(string); ok && len(created) > len("2006-01-02") {
+		data["created"] = created[:len("2006-01-02")]
+	}
+	// vcpus changed from integer to decimal, and isn't used by atlas
+	delete(data, "vcpus")
+	// nested objects like the terminations of circuits or the assigned object of ips changed the same way
+	for field, v := range data {
+		switch field {
+		case "custom_fields", "config_context", "local_context_data":
+			// user defined, taken as they are
+			continue
+		}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			normalize(v)
+		case []interface{}:
+			for _, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					normalize(m)
+				}
+			}
+		}
+	}
+}
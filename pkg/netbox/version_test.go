@@ -0,0 +1,135 @@
+package netbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Version
+		wantErr bool
+	}{
+		{in: "3.4.2", want: Version{Major: 3, Minor: 4}},
+		{in: "v3.5-beta1", want: Version{Major: 3, Minor: 5}},
+		{in: "2.10", want: Version{Major: 2, Minor: 10}},
+		{in: "4.0.0-dev", want: Version{Major: 4, Minor: 0}},
+		{in: "3", wantErr: true},
+		{in: "x.1", wantErr: true},
+		{in: "3.beta", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseVersion(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseVersion(%q) error = %v, wantErr %t", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseVersion(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAtLeast(t *testing.T) {
+	v := Version{Major: 3, Minor: 4}
+	for _, tt := range []struct {
+		major, minor int
+		want         bool
+	}{{2, 11, true}, {3, 0, true}, {3, 4, true}, {3, 5, false}, {4, 0, false}} {
+		if got := v.AtLeast(tt.major, tt.minor); got != tt.want {
+			t.Errorf("%s.AtLeast(%d, %d) = %t, want %t", v, tt.major, tt.minor, got, tt.want)
+		}
+	}
+}
+
+func TestStatusParam(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name    string
+		version Version
+		status  *string
+		want    *string
+	}{
+		{name: "none", version: Version{Major: 3, Minor: 0}},
+		{name: "legacy integer", version: Version{Major: 3, Minor: 0}, status: str("1"), want: str("active")},
+		{name: "slug", version: Version{Major: 3, Minor: 0}, status: str("offline"), want: str("offline")},
+		{name: "unknown integer", version: Version{Major: 2, Minor: 10}, status: str("9"), want: str("9")},
+		{name: "legacy server", version: legacyVersion, status: str("1"), want: str("1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := tt.version
+			nb := &Netbox{version: &versionState{version: &v}}
+			got, err := nb.statusParam(tt.status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("statusParam() = %v, want %v", deref(got), deref(tt.want))
+			}
+		})
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return "<nil>"
+	}
+	return *s
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   map[string]interface{}
+		want map[string]interface{}
+	}{
+		{
+			name: "display name",
+			in:   map[string]interface{}{"display": "node001"},
+			want: map[string]interface{}{"display": "node001", "display_name": "node001"},
+		},
+		{
+			name: "device role",
+			in:   map[string]interface{}{"device_type": 1.0, "role": "server"},
+			want: map[string]interface{}{"device_type": 1.0, "role": "server", "device_role": "server"},
+		},
+		{
+			name: "primary ip",
+			in:   map[string]interface{}{"primary_ip4": nil, "primary_ip6": "ip6"},
+			want: map[string]interface{}{"primary_ip4": nil, "primary_ip6": "ip6", "primary_ip": "ip6"},
+		},
+		{
+			name: "connected endpoints",
+			in:   map[string]interface{}{"connected_endpoints": []interface{}{"a", "b"}, "connected_endpoints_type": "dcim.interface"},
+			want: map[string]interface{}{
+				"connected_endpoints": []interface{}{"a", "b"}, "connected_endpoints_type": "dcim.interface",
+				"connected_endpoint": "a", "connected_endpoint_type": "dcim.interface",
+			},
+		},
+		{
+			name: "cable peer",
+			in:   map[string]interface{}{"cable_peer": "a", "cable_peer_type": "dcim.interface"},
+			want: map[string]interface{}{
+				"cable_peer": "a", "cable_peer_type": "dcim.interface",
+				"link_peer": "a", "link_peer_type": "dcim.interface",
+			},
+		},
+		{
+			name: "decimals and dates",
+			in:   map[string]interface{}{"position": 10.5, "latitude": 49.5, "created": "2021-03-01T12:00:00Z", "vcpus": 2.5},
+			want: map[string]interface{}{"position": 10.0, "latitude": "49.5", "created": "2021-03-01"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalize(tt.in)
+			if !reflect.DeepEqual(tt.in, tt.want) {
+				t.Errorf("normalize() = %v, want %v", tt.in, tt.want)
+			}
+		})
+	}
+}